@@ -0,0 +1,85 @@
+package main
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/ko/pkg/build"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// annotateResult sets index level and manifest level annotations on a build result.
+// Annotations targeting the index land on the image manifest when the result is a
+// single platform image, since there is no index to put them on.
+func annotateResult(r build.Result, indexAnnotations, manifestAnnotations map[string]string) (build.Result, error) {
+	if len(indexAnnotations) == 0 && len(manifestAnnotations) == 0 {
+		return r, nil
+	}
+
+	mt, err := r.MediaType()
+	if err != nil {
+		return nil, errors.Wrap(err, "get result media type")
+	}
+
+	if !mt.IsIndex() {
+		img, ok := r.(v1.Image)
+		if !ok {
+			return nil, errors.Errorf("unexpected build result %T for %s", r, mt)
+		}
+		return mutate.Annotations(img, lo.Assign(indexAnnotations, manifestAnnotations)).(v1.Image), nil
+	}
+
+	idx, ok := r.(v1.ImageIndex)
+	if !ok {
+		return nil, errors.Errorf("unexpected build result %T for %s", r, mt)
+	}
+
+	if len(manifestAnnotations) > 0 {
+		idx, err = mapIndexImages(idx, func(img v1.Image) (v1.Image, error) {
+			return mutate.Annotations(img, manifestAnnotations).(v1.Image), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mutate.Annotations(idx, indexAnnotations).(v1.ImageIndex), nil
+}
+
+// mapIndexImages rebuilds the index with every platform image replaced by fn's result.
+func mapIndexImages(idx v1.ImageIndex, fn func(v1.Image) (v1.Image, error)) (v1.ImageIndex, error) {
+	mt, err := idx.MediaType()
+	if err != nil {
+		return nil, errors.Wrap(err, "get index media type")
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "get index manifest")
+	}
+
+	adds := make([]mutate.IndexAddendum, 0, len(im.Manifests))
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get image %s from index", desc.Digest)
+		}
+		img, err = fn(img)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				MediaType:   desc.MediaType,
+				URLs:        desc.URLs,
+				Annotations: desc.Annotations,
+				Platform:    desc.Platform,
+			},
+		})
+	}
+
+	mapped := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, mt), adds...)
+	return mutate.Annotations(mapped, im.Annotations).(v1.ImageIndex), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+	"github.com/pkg/errors"
+)
+
+const (
+	// replaced in attachment paths so each service can attach its own file
+	serviceNamePlaceholder = "SERVICE_NAME"
+	artifactTypePrefix     = "application/vnd.ippon."
+)
+
+type Attachment struct {
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+type attachSpec struct {
+	artifactType string
+	path         string
+}
+
+// parseAttachSpecs parses --attach values of the form type=path. A type without a slash
+// is turned into an application/vnd.ippon.<type> artifact type.
+func parseAttachSpecs(values []string) ([]attachSpec, error) {
+	specs := make([]attachSpec, 0, len(values))
+	for _, value := range values {
+		artifactType, path, ok := strings.Cut(value, "=")
+		if !ok || artifactType == "" || path == "" {
+			return nil, errors.Errorf("invalid attachment %q, expected type=path", value)
+		}
+		if !strings.Contains(artifactType, "/") {
+			artifactType = artifactTypePrefix + artifactType
+		}
+		specs = append(specs, attachSpec{artifactType: artifactType, path: path})
+	}
+	return specs, nil
+}
+
+// attachArtifact pushes the file as an OCI artifact whose subject is the published image,
+// so it is listed by the referrers API (or the referrers fallback tag on older registries).
+func attachArtifact(ctx context.Context, repo name.Repository, subject build.Result, spec attachSpec, serviceName string, options ...remote.Option) (*Attachment, error) {
+	path := strings.ReplaceAll(spec.path, serviceNamePlaceholder, serviceName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read attachment")
+	}
+
+	subjectDesc, err := partial.Descriptor(subject)
+	if err != nil {
+		return nil, errors.Wrap(err, "describe attachment subject")
+	}
+
+	artifact, err := mutate.Append(
+		mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.MediaType(spec.artifactType)),
+		mutate.Addendum{
+			Layer: static.NewLayer(data, types.MediaType("application/octet-stream")),
+			Annotations: map[string]string{
+				"org.opencontainers.image.title": filepath.Base(path),
+			},
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create attachment artifact")
+	}
+	artifactImage := mutate.Subject(artifact, v1.Descriptor{
+		MediaType: subjectDesc.MediaType,
+		Size:      subjectDesc.Size,
+		Digest:    subjectDesc.Digest,
+	}).(v1.Image)
+
+	digest, err := artifactImage.Digest()
+	if err != nil {
+		return nil, errors.Wrap(err, "get attachment digest")
+	}
+
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	if err := remote.Write(repo.Digest(digest.String()), artifactImage, options...); err != nil {
+		return nil, errors.Wrap(err, "push attachment")
+	}
+
+	return &Attachment{
+		Type:   spec.artifactType,
+		Path:   path,
+		Digest: digest.String(),
+	}, nil
+}
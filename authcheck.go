@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// staticKeychain answers every registry with the same credentials.
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (this staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return this.auth, nil
+}
+
+// authCheckCommand verifies, without building anything, that the resolved registry
+// credentials are allowed to push to the repository of every service.
+func authCheckCommand(ctx context.Context, cmd *cobra.Command, _ []string, registryName string) error {
+	configPaths, err := cmd.Flags().GetStringArray("config")
+	if err != nil {
+		return errors.Wrap(err, "failed getting config flag")
+	}
+
+	config, err := getConfig(registryName, configPaths)
+	if err != nil {
+		return errors.Wrap(err, "get services config")
+	}
+
+	namespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return errors.Wrap(err, "failed getting namespace flag")
+	}
+
+	keychain, err := buildKeychain(config.Keychains, config.ECR)
+	if err != nil {
+		return errors.Wrap(err, "build registry keychain")
+	}
+	if selfAuth, ok := config.Registry.(SelfAuthRegistry); ok {
+		keychain = staticKeychain{auth: selfAuth.Authenticator()}
+	}
+
+	if config.ECR != nil {
+		if _, _, err := config.ECR.CredentialHelper().Get(config.ECR.URL()); err != nil {
+			return &RegistryAuthError{Registry: config.ECR.URL(), Err: errors.Wrap(err, "ECR authorization token is not obtainable")}
+		}
+		fmt.Printf("ok: ECR authorization token for %s\n", config.ECR.URL())
+	}
+
+	baseURLs := []string{config.Registry.URL()}
+	if multi, ok := config.Registry.(MultiURLRegistry); ok {
+		baseURLs = multi.URLs()
+	}
+
+	failed := 0
+	for _, baseURL := range baseURLs {
+		for _, service := range config.ServicesConfig.GoServices {
+			repoName, err := service.RepoName(namespace)
+			if err != nil {
+				return err
+			}
+			repo, err := name.NewRepository(path.Join(baseURL, repoName))
+			if err != nil {
+				return errors.Wrapf(err, "parse repository of %s", service.Name)
+			}
+
+			err = remote.CheckPushPermission(repo.Tag("ippon-auth-check"), keychain, remote.DefaultTransport)
+			if err != nil {
+				failed++
+				fmt.Printf("fail: %s: %v\n", repo, err)
+				continue
+			}
+			fmt.Printf("ok: %s\n", repo)
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("push would fail for %d repositories", failed)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Package backend holds the builders and publishers ippon can release services with.
+//
+// A builder turns a service's main package into an image. ippon calls Build(ctx, "") on the
+// returned build.Interface, an empty import path meaning "the main package in BuildOptions.Dir".
+// The result must be a v1.Image or v1.ImageIndex whose Digest() is the digest ippon records in
+// the manifest.
+//
+// A publisher uploads a build result. Publish(ctx, result, repo) receives the repository name
+// relative to PublishOptions.BaseURL and must return a reference whose Context() names the
+// repository the image landed in. Publishing the same result twice must be safe, and every tag
+// in PublishOptions.Tags must point at the result once Publish returns without an error.
+//
+// Custom implementations register themselves under a name, usually from an init function of
+// a package linked into ippon or of a Go plugin listed in the `plugins` config, and are selected
+// with the `builder` and `publisher` config keys. Registered keychains can likewise be listed in
+// the `keychains` config, next to the built-in ones.
+package backend
+
+import (
+	"context"
+	"plugin"
+	"sort"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	DefaultBuilder   = "ko"
+	DefaultPublisher = "default"
+	// LocalPublisher loads images into the local Docker daemon, BaseURL being the local domain
+	LocalPublisher = "local"
+)
+
+type BuildOptions struct {
+	// Dir is the directory of the service's main package
+	Dir string
+	// Platforms to build, as os/arch[/variant]
+	Platforms []string
+	// BaseImage is the base image reference, BASE_URL already replaced with the registry URL
+	BaseImage string
+	// PlatformBaseImages, when set, replaces BaseImage with a base image per platform, resolved the same way
+	PlatformBaseImages map[string]string
+	// RemoteOptions must be used for any registry access, they carry the registry auth
+	RemoteOptions []remote.Option
+	// SBOM generates an SPDX SBOM, the default publisher pushes it next to the image
+	SBOM bool
+	// BasePulls is shared by all builds of a release and bounds concurrent base image pulls
+	BasePulls *semaphore.Weighted
+	// Labels are set on the image config of every platform
+	Labels map[string]string
+	// PlatformConcurrency bounds the platforms of the service built at once, 0 for the builder's default
+	PlatformConcurrency int
+	// Ldflags are passed to go build, already templated
+	Ldflags []string
+}
+
+type PublishOptions struct {
+	// BaseURL is the registry, and optional path, repositories are created under
+	BaseURL string
+	Tags    []string
+	// PublishOptions carry the registry auth
+	PublishOptions []publish.Option
+}
+
+type BuilderFactory func(ctx context.Context, opts BuildOptions) (build.Interface, error)
+
+type PublisherFactory func(ctx context.Context, opts PublishOptions) (publish.Interface, error)
+
+var (
+	mu         sync.RWMutex
+	builders   = map[string]BuilderFactory{DefaultBuilder: newKoBuilder}
+	publishers = map[string]PublisherFactory{DefaultPublisher: newDefaultPublisher, LocalPublisher: newLocalPublisher}
+	keychains  = map[string]authn.Keychain{}
+)
+
+// RegisterBuilder makes a builder available under name, replacing any builder with the same name.
+func RegisterBuilder(name string, factory BuilderFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	builders[name] = factory
+}
+
+// RegisterPublisher makes a publisher available under name, replacing any publisher with the same name.
+func RegisterPublisher(name string, factory PublisherFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	publishers[name] = factory
+}
+
+// RegisterKeychain makes a keychain available under name, replacing any keychain with the same name.
+func RegisterKeychain(name string, keychain authn.Keychain) {
+	mu.Lock()
+	defer mu.Unlock()
+	keychains[name] = keychain
+}
+
+func GetBuilder(name string) (BuilderFactory, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := builders[name]
+	if !ok {
+		return nil, errors.Errorf("unknown builder %q, registered: %v", name, sortedKeys(builders))
+	}
+	return factory, nil
+}
+
+func GetPublisher(name string) (PublisherFactory, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := publishers[name]
+	if !ok {
+		return nil, errors.Errorf("unknown publisher %q, registered: %v", name, sortedKeys(publishers))
+	}
+	return factory, nil
+}
+
+func GetKeychain(name string) (authn.Keychain, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	keychain, ok := keychains[name]
+	if !ok {
+		return nil, errors.Errorf("unknown keychain %q, registered: %v", name, sortedKeys(keychains))
+	}
+	return keychain, nil
+}
+
+// LoadPlugins opens Go plugins, which are expected to register their builders and publishers
+// from init. Plugins must be built with the same Go toolchain and module versions as ippon.
+func LoadPlugins(paths []string) error {
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return errors.Wrapf(err, "load plugin %s", path)
+		}
+	}
+	return nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,321 @@
+package backend
+
+import (
+	"context"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	ocimutate "github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	"github.com/sigstore/cosign/v2/pkg/oci/signed"
+)
+
+// koBuilder sets the OCI base image annotations on the index of multi-platform builds.
+// ko only sets them on each platform image, pointing at the platform's base image. With a
+// base image per platform, ko points every platform image at the same one, koBuilder
+// points each back at its own.
+type koBuilder struct {
+	build.Interface
+
+	// platformBases holds the base image name per platform of PlatformBaseImages
+	platformBases map[string]string
+
+	mu         sync.Mutex
+	baseName   string
+	baseDigest v1.Hash
+}
+
+func (this *koBuilder) setBase(ref name.Reference, base build.Result) error {
+	digest, err := base.Digest()
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.baseName = ref.Name()
+	this.baseDigest = digest
+	return nil
+}
+
+func (this *koBuilder) Build(ctx context.Context, ip string) (build.Result, error) {
+	r, err := this.Interface.Build(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, ok := r.(v1.ImageIndex)
+	if !ok {
+		return r, nil
+	}
+
+	var annotated v1.ImageIndex
+	// bases assembled per platform have no single base for the index to point at
+	if len(this.platformBases) > 0 {
+		annotated, err = platformBaseAnnotated(idx, this.platformBases)
+		if err != nil {
+			return nil, errors.Wrap(err, "annotate platform base images")
+		}
+	} else {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		if this.baseName == "" {
+			return r, nil
+		}
+		annotated = mutate.Annotations(idx, map[string]string{
+			specsv1.AnnotationBaseImageName:   this.baseName,
+			specsv1.AnnotationBaseImageDigest: this.baseDigest.String(),
+		}).(v1.ImageIndex)
+	}
+
+	// ko's index carries the SBOM as a cosign attachment, which the annotated copy drops
+	if se, ok := idx.(oci.SignedImageIndex); ok {
+		if sbom, err := se.Attachment("sbom"); err == nil {
+			return ocimutate.AttachFileToImageIndex(signed.ImageIndex(annotated), "sbom", sbom)
+		}
+	}
+	return annotated, nil
+}
+
+func newKoBuilder(ctx context.Context, opts BuildOptions) (build.Interface, error) {
+	sbomOption := build.WithDisabledSBOM()
+	if opts.SBOM {
+		sbomOption = build.WithSPDX("ippon")
+	}
+
+	builder := &koBuilder{platformBases: map[string]string{}}
+	for platform, base := range opts.PlatformBaseImages {
+		ref, err := name.ParseReference(base)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s base image", platform)
+		}
+		builder.platformBases[platform] = ref.Name()
+	}
+
+	options := []build.Option{
+		build.WithPlatforms(opts.Platforms...),
+		sbomOption,
+		build.WithBaseImages(func(ctx context.Context, _ string) (name.Reference, build.Result, error) {
+			if opts.BasePulls != nil {
+				if err := opts.BasePulls.Acquire(ctx, 1); err != nil {
+					return nil, nil, err
+				}
+				defer opts.BasePulls.Release(1)
+			}
+
+			remoteOptions := append([]remote.Option{remote.WithContext(ctx)}, opts.RemoteOptions...)
+			if len(opts.PlatformBaseImages) > 0 {
+				return platformBaseIndex(opts.PlatformBaseImages, remoteOptions)
+			}
+
+			ref, err := name.ParseReference(opts.BaseImage)
+			if err != nil {
+				return nil, nil, err
+			}
+			base, err := baseResult(ref, remoteOptions)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := builder.setBase(ref, base); err != nil {
+				return nil, nil, err
+			}
+			return ref, base, nil
+		}),
+	}
+	for key, value := range opts.Labels {
+		options = append(options, build.WithLabel(key, value))
+	}
+	// ko builds the main package of Dir, whose import path it keys build configs with is empty
+	if len(opts.Ldflags) > 0 {
+		options = append(options, build.WithConfig(map[string]build.Config{
+			"": {Ldflags: opts.Ldflags},
+		}))
+	}
+	// ko builds every platform concurrently, up to GOMAXPROCS go builds at a time
+	if opts.PlatformConcurrency > 0 {
+		options = append(options, build.WithJobs(opts.PlatformConcurrency))
+	}
+
+	g, err := build.NewGo(ctx, opts.Dir, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	builder.Interface = g
+	return builder, nil
+}
+
+// baseResult fetches the base image referenced by ref, which may be a multi-platform index
+// or a single platform image.
+func baseResult(ref name.Reference, remoteOptions []remote.Option) (build.Result, error) {
+	desc, err := remote.Get(ref, remoteOptions...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get base image %s", ref)
+	}
+
+	if desc.MediaType.IsIndex() {
+		return desc.ImageIndex()
+	}
+	if desc.MediaType.IsImage() {
+		return desc.Image()
+	}
+	return nil, errors.Errorf("base image %s is neither an image nor an index, but %s", ref, desc.MediaType)
+}
+
+// platformBaseIndex assembles an index out of a base image per platform, letting ko pick
+// the right one for each platform it builds. The returned reference, which ko annotates
+// every platform image with, is the base of the first platform. platformBaseAnnotated
+// corrects the others.
+func platformBaseIndex(bases map[string]string, remoteOptions []remote.Option) (name.Reference, build.Result, error) {
+	platforms := make([]string, 0, len(bases))
+	for platform := range bases {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	var firstRef name.Reference
+	adds := make([]mutate.IndexAddendum, 0, len(platforms))
+	for _, platformStr := range platforms {
+		platform, err := v1.ParsePlatform(platformStr)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "parse platform %s", platformStr)
+		}
+
+		ref, err := name.ParseReference(bases[platformStr])
+		if err != nil {
+			return nil, nil, err
+		}
+		if firstRef == nil {
+			firstRef = ref
+		}
+
+		img, err := platformImage(ref, platform, remoteOptions)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "get %s base image %s", platformStr, ref)
+		}
+
+		mt, err := img.MediaType()
+		if err != nil {
+			return nil, nil, err
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				MediaType: mt,
+				Platform:  platform,
+			},
+		})
+	}
+
+	return firstRef, mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), adds...), nil
+}
+
+// platformBaseAnnotated returns idx with the base image name annotation of each platform
+// image set to the base of its platform in bases. The base digest ko set is already the
+// platform's. Platforms missing from bases are left as they are.
+func platformBaseAnnotated(idx v1.ImageIndex, bases map[string]string) (v1.ImageIndex, error) {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := idx.MediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	adds := make([]mutate.IndexAddendum, 0, len(im.Manifests))
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if base, ok := platformBase(bases, desc.Platform); ok {
+			img = mutate.Annotations(img, map[string]string{
+				specsv1.AnnotationBaseImageName: base,
+			}).(v1.Image)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				URLs:        desc.URLs,
+				MediaType:   desc.MediaType,
+				Annotations: desc.Annotations,
+				Platform:    desc.Platform,
+			},
+		})
+	}
+
+	base := mutate.Annotations(mutate.IndexMediaType(empty.Index, mt), im.Annotations).(v1.ImageIndex)
+	return mutate.AppendManifests(base, adds...), nil
+}
+
+// platformBase returns the base in bases of the platform, keyed like platform_base_images.
+func platformBase(bases map[string]string, platform *v1.Platform) (string, bool) {
+	if platform == nil {
+		return "", false
+	}
+	if base, ok := bases[platform.String()]; ok {
+		return base, true
+	}
+	for platformStr, base := range bases {
+		p, err := v1.ParsePlatform(platformStr)
+		if err == nil && platform.Satisfies(*p) {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// platformImage returns the image referenced by ref, picking the one matching platform
+// when ref is an index.
+func platformImage(ref name.Reference, platform *v1.Platform, remoteOptions []remote.Option) (v1.Image, error) {
+	desc, err := remote.Get(ref, remoteOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !desc.MediaType.IsIndex() {
+		return desc.Image()
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range im.Manifests {
+		if m.Platform != nil && m.Platform.Satisfies(*platform) {
+			return idx.Image(m.Digest)
+		}
+	}
+	return nil, errors.Errorf("no %s image in index", platform)
+}
+
+func newDefaultPublisher(_ context.Context, opts PublishOptions) (publish.Interface, error) {
+	return publish.NewDefault(opts.BaseURL,
+		append([]publish.Option{publish.WithTags(opts.Tags)}, opts.PublishOptions...)...,
+	)
+}
+
+// newLocalPublisher loads images into the Docker daemon of DOCKER_HOST, picking the image
+// of GOOS/GOARCH (linux/amd64 by default) out of multi-platform builds. Publish returns
+// the tag the image was loaded as, the daemon knowing nothing of the index digest.
+func newLocalPublisher(_ context.Context, opts PublishOptions) (publish.Interface, error) {
+	return publish.NewDaemon(func(base, repo string) string {
+		return path.Join(base, repo)
+	}, opts.Tags, publish.WithLocalDomain(opts.BaseURL))
+}
@@ -0,0 +1,354 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+)
+
+// testRegistry serves an in-memory registry, returning its host.
+func testRegistry(t testing.TB) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// platformIndex returns an index of a random image per platform.
+func platformIndex(t testing.TB, platforms ...string) v1.ImageIndex {
+	t.Helper()
+	adds := []mutate.IndexAddendum{}
+	for _, platformStr := range platforms {
+		platform, err := v1.ParsePlatform(platformStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: platform},
+		})
+	}
+	return mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), adds...)
+}
+
+func TestBaseResult(t *testing.T) {
+	host := testRegistry(t)
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := parseRef(t, host+"/base/image:latest")
+	if err := remote.Write(imageRef, img); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := platformIndex(t, "linux/amd64", "linux/arm64")
+	indexRef := parseRef(t, host+"/base/index:latest")
+	if err := remote.WriteIndex(indexRef, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	missingRef := parseRef(t, host+"/base/missing:latest")
+
+	tests := []struct {
+		name    string
+		ref     name.Reference
+		want    v1.Hash
+		isIndex bool
+		wantErr bool
+	}{
+		{name: "single image", ref: imageRef, want: digest(t, img)},
+		{name: "index", ref: indexRef, want: digest(t, idx), isIndex: true},
+		{name: "missing", ref: missingRef, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, err := baseResult(test.ref, nil)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, isIndex := base.(v1.ImageIndex)
+			if isIndex != test.isIndex {
+				t.Errorf("base is a %T, index %t", base, test.isIndex)
+			}
+			if got := digest(t, base); got != test.want {
+				t.Errorf("digest %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlatformBaseIndex(t *testing.T) {
+	host := testRegistry(t)
+
+	// amd64 comes from a plain image, arm64 out of a multi-platform index
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(parseRef(t, host+"/base/amd64:latest"), img); err != nil {
+		t.Fatal(err)
+	}
+	idx := platformIndex(t, "linux/amd64", "linux/arm64")
+	if err := remote.WriteIndex(parseRef(t, host+"/base/multi:latest"), idx); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, base, err := platformBaseIndex(map[string]string{
+		"linux/amd64": host + "/base/amd64:latest",
+		"linux/arm64": host + "/base/multi:latest",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Name() != host+"/base/amd64:latest" {
+		t.Errorf("reference %s, want the amd64 base", ref)
+	}
+
+	im, err := base.(v1.ImageIndex).IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]v1.Hash{
+		"linux/amd64": digest(t, img),
+		"linux/arm64": arm64.Manifests[1].Digest,
+	}
+	if len(im.Manifests) != len(want) {
+		t.Fatalf("%d manifests, want %d", len(im.Manifests), len(want))
+	}
+	for _, m := range im.Manifests {
+		if m.Digest != want[m.Platform.String()] {
+			t.Errorf("%s digest %s, want %s", m.Platform, m.Digest, want[m.Platform.String()])
+		}
+	}
+}
+
+func parseRef(t testing.TB, s string) name.Reference {
+	t.Helper()
+	ref, err := name.ParseReference(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func digest(t *testing.T, r interface{ Digest() (v1.Hash, error) }) v1.Hash {
+	t.Helper()
+	h, err := r.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// testModule writes a main package to build, returning its directory.
+func testModule(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/hello\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestKoBuilderKeepsIndexSBOM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a go binary per platform")
+	}
+	host := testRegistry(t)
+
+	baseRef := parseRef(t, host+"/base/multi:latest")
+	if err := remote.WriteIndex(baseRef, platformIndex(t, "linux/amd64", "linux/arm64")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b, err := newKoBuilder(ctx, BuildOptions{
+		Dir:       testModule(t),
+		Platforms: []string{"linux/amd64", "linux/arm64"},
+		BaseImage: baseRef.String(),
+		SBOM:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the package of Dir, spelled out since current go list rejects an empty pattern
+	r, err := b.Build(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, ok := r.(oci.SignedImageIndex)
+	if !ok {
+		t.Fatalf("build result is a %T, want a signed index", r)
+	}
+	sbom, err := idx.Attachment("sbom")
+	if err != nil {
+		t.Fatalf("no SBOM attached: %v", err)
+	}
+	if mt, err := sbom.FileMediaType(); err != nil || !strings.Contains(string(mt), "spdx") {
+		t.Errorf("SBOM media type %s (%v), want spdx", mt, err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Annotations[specsv1.AnnotationBaseImageName] != baseRef.Name() {
+		t.Errorf("base image annotation %q, want %s", manifest.Annotations[specsv1.AnnotationBaseImageName], baseRef.Name())
+	}
+	if len(manifest.Manifests) != 2 {
+		t.Errorf("%d platform images, want 2", len(manifest.Manifests))
+	}
+}
+
+func TestKoBuilderAnnotatesPlatformBases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a go binary per platform")
+	}
+	host := testRegistry(t)
+
+	bases := map[string]string{
+		"linux/amd64": host + "/base/glibc:latest",
+		"linux/arm64": host + "/base/musl:latest",
+	}
+	for _, base := range bases {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(parseRef(t, base), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	b, err := newKoBuilder(ctx, BuildOptions{
+		Dir:                testModule(t),
+		Platforms:          []string{"linux/amd64", "linux/arm64"},
+		PlatformBaseImages: bases,
+		SBOM:               true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := b.Build(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, ok := r.(oci.SignedImageIndex)
+	if !ok {
+		t.Fatalf("build result is a %T, want a signed index", r)
+	}
+	if _, err := idx.Attachment("sbom"); err != nil {
+		t.Errorf("no SBOM attached: %v", err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != len(bases) {
+		t.Fatalf("%d platform images, want %d", len(manifest.Manifests), len(bases))
+	}
+	for _, desc := range manifest.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := img.Manifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := parseRef(t, bases[desc.Platform.String()]).Name()
+		if got := m.Annotations[specsv1.AnnotationBaseImageName]; got != want {
+			t.Errorf("%s base image annotation %q, want %s", desc.Platform, got, want)
+		}
+	}
+}
+
+// BenchmarkKoBuilderTwoPlatforms compares building both platforms of a service one after
+// the other with building them at once.
+func BenchmarkKoBuilderTwoPlatforms(b *testing.B) {
+	host := testRegistry(b)
+	baseRef := parseRef(b, host+"/base/multi:latest")
+	if err := remote.WriteIndex(baseRef, platformIndex(b, "linux/amd64", "linux/arm64")); err != nil {
+		b.Fatal(err)
+	}
+	dir := testModule(b)
+
+	// ko logs every platform it builds
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+
+	for _, concurrency := range []int{1, 2} {
+		b.Run(fmt.Sprintf("platform-concurrency=%d", concurrency), func(b *testing.B) {
+			ctx := context.Background()
+			builder, err := newKoBuilder(ctx, BuildOptions{
+				Dir:                 dir,
+				Platforms:           []string{"linux/amd64", "linux/arm64"},
+				BaseImage:           baseRef.String(),
+				PlatformConcurrency: concurrency,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for i := 0; i < b.N; i++ {
+				if _, err := builder.Build(ctx, "."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+// checkBaseAge warns, or fails when failOnStale is set, if the base image of platform was
+// created more than maxAge ago, according to the creation time in its config.
+func checkBaseAge(baseImage, platform string, maxAge time.Duration, failOnStale bool, remoteOptions ...remote.Option) error {
+	ref, err := name.ParseReference(baseImage)
+	if err != nil {
+		return errors.Wrapf(err, "parse base image %s", baseImage)
+	}
+
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return errors.Wrapf(err, "parse platform %s", platform)
+	}
+
+	img, err := remote.Image(ref, append(remoteOptions, remote.WithPlatform(*p))...)
+	if err != nil {
+		return errors.Wrapf(err, "get base image %s", baseImage)
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return errors.Wrapf(err, "get config of base image %s", baseImage)
+	}
+	if cfg.Created.IsZero() {
+		log.Printf("ippon base image %s has no creation time, skipping age check\n", baseImage)
+		return nil
+	}
+
+	age := time.Since(cfg.Created.Time)
+	if age <= maxAge {
+		return nil
+	}
+	if failOnStale {
+		return errors.Errorf("base image %s is %s old, over max_base_age %s", baseImage, age.Round(time.Hour), maxAge)
+	}
+	log.Printf("ippon WARNING: base image %s is %s old, over max_base_age %s\n", baseImage, age.Round(time.Hour), maxAge)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// bomAnnotationPrefix prefixes the annotation holding each service's image in the release
+// bill of materials image.
+const bomAnnotationPrefix = "dev.ippon.service."
+
+// newReleaseBOM returns an empty image whose annotations reference the pushed image of
+// every service, a single immutable reference to the whole release.
+func newReleaseBOM(results []*ServiceResult, version string) v1.Image {
+	annotations := map[string]string{specsv1.AnnotationVersion: version}
+	for _, result := range results {
+		annotations[bomAnnotationPrefix+result.Service] = result.Image.NewName
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, types.OCIConfigJSON)
+	return mutate.Annotations(img, annotations).(v1.Image)
+}
+
+// pushReleaseBOM pushes the release bill of materials image to repo under baseURL, tagged
+// with every release tag, returning its digest reference.
+func pushReleaseBOM(ctx context.Context, baseURL, repo string, tags []string, results []*ServiceResult, options ...remote.Option) (string, error) {
+	if len(tags) == 0 {
+		return "", errors.New("the release bill of materials needs at least one global tag")
+	}
+
+	bom := newReleaseBOM(results, tags[0])
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	for _, tag := range tags {
+		ref, err := name.NewTag(path.Join(baseURL, repo) + ":" + tag)
+		if err != nil {
+			return "", errors.Wrap(err, "parse bill of materials reference")
+		}
+		if err := remote.Write(ref, bom, options...); err != nil {
+			return "", errors.Wrapf(err, "push bill of materials %s", ref)
+		}
+	}
+
+	digest, err := bom.Digest()
+	if err != nil {
+		return "", err
+	}
+	repository, err := name.NewRepository(path.Join(baseURL, repo))
+	if err != nil {
+		return "", err
+	}
+	return repository.Digest(digest.String()).String(), nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var buildCachePath = path.Join(".ippon", "build-cache.json")
+
+type buildCacheEntry struct {
+	ConfigHash   string   `json:"config_hash"`
+	SourceHash   string   `json:"source_hash"`
+	OldImage     string   `json:"old_image"`
+	NewImage     string   `json:"new_image"`
+	Digest       string   `json:"digest"`
+	Tags         []string `json:"tags"`
+	Repositories []string `json:"repositories"`
+	// RepositoryTags is only set for repositories pushed with other tags than Tags
+	RepositoryTags map[string][]string `json:"repository_tags,omitempty"`
+}
+
+// buildCache remembers what each service was last released from, so services whose
+// source and effective config are unchanged can reuse their previous image.
+type buildCache struct {
+	mu       sync.Mutex
+	path     string
+	Services map[string]*buildCacheEntry `json:"services"`
+}
+
+func loadBuildCache(path string) (*buildCache, error) {
+	c := &buildCache{path: path, Services: map[string]*buildCacheEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading build cache")
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed unmarshalling build cache")
+	}
+	if c.Services == nil {
+		c.Services = map[string]*buildCacheEntry{}
+	}
+
+	return c, nil
+}
+
+func (this *buildCache) Lookup(service, configHash, sourceHash string) (*ServiceResult, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	entry, ok := this.Services[service]
+	if !ok || entry.ConfigHash != configHash || entry.SourceHash != sourceHash {
+		return nil, false
+	}
+
+	return &ServiceResult{
+		Service: service,
+		Image: &Image{
+			OldName: entry.OldImage,
+			NewName: entry.NewImage,
+		},
+		Digest:         entry.Digest,
+		Tags:           entry.Tags,
+		Repositories:   entry.Repositories,
+		RepositoryTags: entry.RepositoryTags,
+	}, true
+}
+
+func (this *buildCache) Record(result *ServiceResult, configHash, sourceHash string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.Services[result.Service] = &buildCacheEntry{
+		ConfigHash:     configHash,
+		SourceHash:     sourceHash,
+		OldImage:       result.Image.OldName,
+		NewImage:       result.Image.NewName,
+		Digest:         result.Digest,
+		Tags:           result.Tags,
+		Repositories:   result.Repositories,
+		RepositoryTags: result.RepositoryTags,
+	}
+}
+
+func (this *buildCache) Save() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	data, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(this.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(this.path, data, 0644)
+}
+
+// serviceConfigHash hashes everything besides source code that ends up in a service's image:
+// its whole config entry, the resolved tags and base image, and the release wide settings.
+func serviceConfigHash(service GoServiceConfig, settings *releaseSettings, tags []string, baseImage string) (string, error) {
+	ldflags, err := settings.ldflagsFor(service)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(struct {
+		Service            GoServiceConfig
+		Tags               []string
+		BaseImage          string
+		PlatformBaseImages map[string]string
+		Platforms          []string
+		Ldflags            []string
+		BaseURLs           []string
+		Namespace          string
+		Builder            string
+		Publisher          string
+		GoVersion          string
+		DebugShell         string
+		RegistryTags       []RegistryTagsConfig
+		DigestTag          string
+		SBOM               bool
+	}{
+		Service:            service,
+		Tags:               tags,
+		BaseImage:          baseImage,
+		PlatformBaseImages: service.GetPlatformBaseImages(),
+		Platforms:          settings.platformsFor(service),
+		Ldflags:            ldflags,
+		BaseURLs:           append([]string{settings.baseURL}, settings.extraBaseURLs...),
+		Namespace:          settings.namespace,
+		Builder:            settings.builder,
+		Publisher:          settings.publisher,
+		GoVersion:          settings.goVersion,
+		DebugShell:         settings.debugShell,
+		RegistryTags:       settings.registryTags,
+		DigestTag:          settings.digestTag,
+		SBOM:               settings.sbom,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type goListPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	GoFiles    []string
+	CgoFiles   []string
+	EmbedFiles []string
+	Module     *struct {
+		Path    string
+		Version string
+	}
+}
+
+// serviceSourceHash hashes the source of the main package in dir and of every package it
+// depends on. Standard library packages are covered by the go version in the config hash,
+// and packages of versioned modules by their module version.
+func serviceSourceHash(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-deps", "-json", ".")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrap(err, "go list dependencies")
+	}
+
+	var pkgs []goListPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg goListPackage
+		err := dec.Decode(&pkg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "decode go list output")
+		}
+		if !pkg.Standard {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].ImportPath < pkgs[j].ImportPath
+	})
+
+	h := sha256.New()
+	for _, pkg := range pkgs {
+		io.WriteString(h, pkg.ImportPath+"\n")
+		if pkg.Module != nil && pkg.Module.Version != "" {
+			io.WriteString(h, pkg.Module.Path+"@"+pkg.Module.Version+"\n")
+			continue
+		}
+
+		files := append(append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...), pkg.EmbedFiles...)
+		sort.Strings(files)
+		for _, file := range files {
+			data, err := os.ReadFile(filepath.Join(pkg.Dir, file))
+			if err != nil {
+				return "", errors.Wrap(err, "read source file")
+			}
+			io.WriteString(h, file+"\n")
+			h.Write(data)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
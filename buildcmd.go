@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/lema-ai/ippon/backend"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/semaphore"
+)
+
+const tarOutput = "tar"
+
+// buildCommand builds a single service without publishing it, no registry credentials
+// needed besides pulling the base image, and prints the image digest.
+func buildCommand(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	configPaths, err := cmd.Flags().GetStringArray("config")
+	if err != nil {
+		return errors.Wrap(err, "failed getting config flag")
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return errors.Wrap(err, "failed getting output flag")
+	}
+	if output != "" && output != tarOutput {
+		return errors.Errorf("unknown output %q, expected %s", output, tarOutput)
+	}
+
+	tarFile, err := cmd.Flags().GetString("tar-file")
+	if err != nil {
+		return errors.Wrap(err, "failed getting tar-file flag")
+	}
+
+	platforms, err := cmd.Flags().GetStringSlice("platform")
+	if err != nil {
+		return errors.Wrap(err, "failed getting platform flag")
+	}
+
+	config, err := getRegistrylessConfig(configPaths)
+	if err != nil {
+		return errors.Wrap(err, "get services config")
+	}
+	if problems := config.ServicesConfig.Validate(); len(problems) > 0 {
+		return &ConfigError{Err: errors.Wrap(aggregateErrors(problems), "invalid config")}
+	}
+
+	service, ok := lo.Find(config.ServicesConfig.GoServices, func(s GoServiceConfig) bool {
+		return s.Name == args[0]
+	})
+	if !ok {
+		return errors.Errorf("unknown service %s", args[0])
+	}
+
+	// without a registry there is nothing to replace BASE_URL with
+	baseImage := service.GetBaseImage()
+	if strings.Contains(baseImage, baseURLPlaceholder) || lo.SomeBy(lo.Values(service.GetPlatformBaseImages()), func(base string) bool {
+		return strings.Contains(base, baseURLPlaceholder)
+	}) {
+		return errors.Errorf("the base image of %s refers to BASE_URL, which needs a registry command", service.Name)
+	}
+
+	if len(platforms) == 0 {
+		platforms = service.GetPlatforms()
+	}
+
+	goVersion, err := useGoToolchain(ctx, viper.GetString("go_version"))
+	if err != nil {
+		return errors.Wrap(err, "set go toolchain")
+	}
+	log.Printf("ippon building with %s\n", goVersion)
+
+	var git *gitInfo
+	if len(service.GetLdflags()) > 0 {
+		git, err = readGitInfo(ctx)
+		if err != nil {
+			log.Printf("ippon ldflags can't refer to .Git: %v\n", err)
+		}
+	}
+	ldflags, err := expandLdflags(service.GetLdflags(), git)
+	if err != nil {
+		return err
+	}
+
+	keychain, err := buildKeychain(config.Keychains, nil)
+	if err != nil {
+		return errors.Wrap(err, "build registry keychain")
+	}
+
+	newBuilder, err := backend.GetBuilder(config.Builder)
+	if err != nil {
+		return err
+	}
+	b, err := newBuilder(ctx, backend.BuildOptions{
+		Dir:                service.GetMainDir(),
+		Platforms:          platforms,
+		BaseImage:          baseImage,
+		PlatformBaseImages: service.GetPlatformBaseImages(),
+		RemoteOptions:      []remote.Option{remote.WithAuthFromKeychain(keychain)},
+		BasePulls:          semaphore.NewWeighted(1),
+		Ldflags:            ldflags,
+	})
+	if err != nil {
+		return &BuildError{Service: service.Name, Err: errors.Wrap(err, "build go image")}
+	}
+
+	r, err := b.Build(ctx, "")
+	if err != nil {
+		return &BuildError{Service: service.Name, Err: errors.Wrap(err, "build image")}
+	}
+
+	digest, err := r.Digest()
+	if err != nil {
+		return errors.Wrap(err, "get image digest")
+	}
+
+	if output == tarOutput {
+		if tarFile == "" {
+			tarFile = service.Name + ".tar"
+		}
+		err = writeOCITarball(tarFile, r, service.OldImageName())
+		if err != nil {
+			return errors.Wrapf(err, "write %s", tarFile)
+		}
+		log.Printf("ippon wrote %s to %s\n", service.Name, tarFile)
+	}
+
+	fmt.Printf("%s %s\n", service.Name, digest)
+	return nil
+}
+
+// writeOCITarball archives r as an OCI image layout, the format of docker buildx's oci
+// exporter, its manifest annotated with refName.
+func writeOCITarball(filePath string, r build.Result, refName string) error {
+	dir, err := os.MkdirTemp("", "ippon-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+	option := layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": refName})
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		err = p.AppendIndex(r, option)
+	case v1.Image:
+		err = p.AppendImage(r, option)
+	default:
+		err = errors.Errorf("unexpected build result %T", r)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name, err = filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(header.Name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	helmConfigMediaType types.MediaType = "application/vnd.cncf.helm.config.v1+json"
+	helmChartMediaType  types.MediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// chartMetadata is the Chart.yaml of a chart directory, Name and Version being the ones
+// the chart is pushed under.
+type chartMetadata struct {
+	Name    string
+	Version string
+	raw     map[string]interface{}
+}
+
+func readChartMetadata(dir string) (*chartMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read Chart.yaml")
+	}
+
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal Chart.yaml")
+	}
+
+	name, _ := raw["name"].(string)
+	version, _ := raw["version"].(string)
+	if name == "" || version == "" {
+		return nil, errors.Errorf("Chart.yaml in %s needs a name and a version", dir)
+	}
+	return &chartMetadata{Name: name, Version: version, raw: raw}, nil
+}
+
+// chartRepoName returns the repository the chart of service is pushed to, repo_template
+// applied with charts/<name> as the service. By default that's charts/<name> next to the
+// service repositories, the layout helm push uses for oci://<registry>/<namespace>/charts.
+func chartRepoName(service GoServiceConfig, namespace, chartName string) (string, error) {
+	service.Name = path.Join("charts", chartName)
+	return service.RepoName(namespace)
+}
+
+// packageChart archives the chart directory the way helm package does, under a top level
+// directory named after the chart. Timestamps are zeroed so the digest only depends on
+// the content.
+func packageChart(dir, chartName string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:     path.Join(chartName, filepath.ToSlash(rel)),
+			Mode:     int64(info.Mode().Perm()),
+			Size:     info.Size(),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "archive chart")
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chartArtifact is a Helm chart OCI artifact, whose config is the chart metadata as JSON,
+// which ggcr's mutate package can't produce.
+type chartArtifact struct {
+	config   []byte
+	chart    v1.Layer
+	manifest []byte
+}
+
+func newChartArtifact(metadata *chartMetadata, chart []byte) (v1.Image, error) {
+	config, err := json.Marshal(metadata.raw)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := static.NewLayer(chart, helmChartMediaType)
+	layerDigest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	configDigest, _, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config: v1.Descriptor{
+			MediaType: helmConfigMediaType,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+		Layers: []v1.Descriptor{{
+			MediaType: helmChartMediaType,
+			Size:      int64(len(chart)),
+			Digest:    layerDigest,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(&chartArtifact{config: config, chart: layer, manifest: manifest})
+}
+
+func (this *chartArtifact) RawConfigFile() ([]byte, error) { return this.config, nil }
+
+func (this *chartArtifact) MediaType() (types.MediaType, error) { return types.OCIManifestSchema1, nil }
+
+func (this *chartArtifact) RawManifest() ([]byte, error) { return this.manifest, nil }
+
+func (this *chartArtifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	digest, err := this.chart.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if h != digest {
+		return nil, errors.Errorf("unknown chart layer %s", h)
+	}
+	return this.chart, nil
+}
+
+// pushChart packages the chart in dir and pushes it to repository tagged with its version,
+// or with stagingTag when set for the release to promote it. It returns the reference of
+// the chart version.
+func pushChart(ctx context.Context, repository, dir string, metadata *chartMetadata, stagingTag string, options ...remote.Option) (string, error) {
+	chart, err := packageChart(dir, metadata.Name)
+	if err != nil {
+		return "", err
+	}
+
+	artifact, err := newChartArtifact(metadata, chart)
+	if err != nil {
+		return "", errors.Wrap(err, "create chart artifact")
+	}
+
+	ref, err := name.NewTag(repository + ":" + metadata.Version)
+	if err != nil {
+		return "", errors.Wrap(err, "parse chart reference")
+	}
+	pushRef := ref
+	if stagingTag != "" {
+		pushRef = ref.Context().Tag(stagingTag)
+	}
+
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	if err := remote.Write(pushRef, artifact, options...); err != nil {
+		return "", errors.Wrap(err, "push chart")
+	}
+	return ref.String(), nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestChartRepoName(t *testing.T) {
+	tests := []struct {
+		name         string
+		repoTemplate string
+		want         string
+	}{
+		{name: "default", want: "lema/charts/api"},
+		{name: "repo_template", repoTemplate: "{{.Team}}/{{.Service}}", want: "core/charts/api"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("repo_template", test.repoTemplate)
+			t.Cleanup(func() { viper.Set("repo_template", "") })
+
+			got, err := chartRepoName(GoServiceConfig{Name: "api-server", Team: "core"}, "lema", "api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("chart repository %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+var checkpointPath = path.Join(".ippon", "checkpoint.json")
+
+type checkpointEntry struct {
+	OldImage     string   `json:"old_image"`
+	NewImage     string   `json:"new_image"`
+	Digest       string   `json:"digest"`
+	Tags         []string `json:"tags"`
+	Repositories []string `json:"repositories"`
+	// RepositoryTags is only set for repositories pushed with other tags than Tags
+	RepositoryTags map[string][]string `json:"repository_tags,omitempty"`
+}
+
+// checkpoint records every service published by a release as soon as it is done,
+// so an interrupted release can be resumed without rebuilding them.
+type checkpoint struct {
+	mu       sync.Mutex
+	path     string
+	Services map[string]*checkpointEntry `json:"services"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, Services: map[string]*checkpointEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading checkpoint")
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed unmarshalling checkpoint")
+	}
+	if c.Services == nil {
+		c.Services = map[string]*checkpointEntry{}
+	}
+
+	return c, nil
+}
+
+func (this *checkpoint) Record(result *ServiceResult) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.Services[result.Service] = &checkpointEntry{
+		OldImage:       result.Image.OldName,
+		NewImage:       result.Image.NewName,
+		Digest:         result.Digest,
+		Tags:           result.Tags,
+		Repositories:   result.Repositories,
+		RepositoryTags: result.RepositoryTags,
+	}
+
+	data, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(this.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// write and rename so an interruption never leaves a truncated checkpoint behind
+	tmp := this.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.path)
+}
+
+// Resume returns the checkpointed result of service, provided its image can still
+// be found in the registry.
+func (this *checkpoint) Resume(ctx context.Context, service string, options ...remote.Option) (*ServiceResult, bool) {
+	this.mu.Lock()
+	entry, ok := this.Services[service]
+	this.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	ref, err := name.ParseReference(entry.NewImage)
+	if err != nil {
+		log.Printf("ippon ignoring checkpoint of %s: %v\n", service, err)
+		return nil, false
+	}
+
+	_, err = remote.Head(ref, append([]remote.Option{remote.WithContext(ctx)}, options...)...)
+	if err != nil {
+		log.Printf("ippon ignoring checkpoint of %s, image not found in registry: %v\n", service, err)
+		return nil, false
+	}
+
+	return &ServiceResult{
+		Service: service,
+		Image: &Image{
+			OldName: entry.OldImage,
+			NewName: entry.NewImage,
+		},
+		Digest:         entry.Digest,
+		Tags:           entry.Tags,
+		Repositories:   entry.Repositories,
+		RepositoryTags: entry.RepositoryTags,
+	}, true
+}
+
+func (this *checkpoint) Clear() error {
+	err := os.Remove(this.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -2,16 +2,27 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
 
+	"github.com/lema-ai/ippon/backend"
 	"github.com/lema-ai/ippon/registry"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
+	Registry       Registry
 	ECR            *registry.ECR
 	ServicesConfig *ServicesConfig
+	Keychains      []string
+	Builder        string
+	Publisher      string
 }
 
 type ServicesConfig struct {
@@ -23,13 +34,136 @@ type Target struct {
 	Target string `mapstructure:"target"`
 }
 
+// RegistryTagsConfig overrides the tags of images pushed under a registry URL, or
+// any URL below it, when one build is published to several registries.
+type RegistryTagsConfig struct {
+	Registry string   `mapstructure:"registry"`
+	Tags     []string `mapstructure:"tags"`
+}
+
+func getRegistryTags() ([]RegistryTagsConfig, error) {
+	var registryTags []RegistryTagsConfig
+	err := viper.UnmarshalKey("registry_tags", &registryTags)
+	if err != nil {
+		return nil, &ConfigError{Err: errors.Wrap(err, "failed unmarshalling registry_tags")}
+	}
+	return registryTags, nil
+}
+
 type GoServiceConfig struct {
-	Name      string   `mapstructure:"name"`
-	Tags      []string `mapstructure:"tags"`
-	Main      string   `mapstructure:"main"`
-	BaseImage string   `mapstructure:"base_image"`
+	Name string   `mapstructure:"name"`
+	Tags []string `mapstructure:"tags"`
+	// Channels are floating tags (stable) pushed along with the version tags
+	Channels []string `mapstructure:"channels"`
+	Main     string   `mapstructure:"main"`
+	// DependsOn names the services to build and roll out before this one
+	DependsOn []string `mapstructure:"depends_on"`
+	// ModuleDir is the directory of the go.mod the service belongs to, Main being relative
+	// to it, for repositories with several Go modules
+	ModuleDir string `mapstructure:"module_dir"`
+	// Chart is a Helm chart directory pushed as an OCI artifact with the image
+	Chart string `mapstructure:"chart"`
+	// Team is available to repo_template
+	Team string `mapstructure:"team"`
+	// OldImagePrefix is the registry of the image names the manifest rewrites
+	OldImagePrefix string `mapstructure:"old_image_prefix"`
+	BaseImage      string `mapstructure:"base_image"`
+	// Ldflags are passed to go build, templated over .Git and .Env
+	Ldflags []string `mapstructure:"ldflags"`
+	// Platforms to build, linux/amd64 and linux/arm64 giving a multi-platform index
+	Platforms []string `mapstructure:"platforms"`
+	// PlatformBaseImages maps platforms (linux/arm64) to the base image to build them on
+	PlatformBaseImages map[string]string `mapstructure:"platform_base_images"`
+	// Annotations go on the index of multi-platform images and on the manifest otherwise
+	Annotations         map[string]string `mapstructure:"annotations"`
+	IndexAnnotations    map[string]string `mapstructure:"index_annotations"`
+	ManifestAnnotations map[string]string `mapstructure:"manifest_annotations"`
+}
+
+// GetMainDir returns the directory of the service's main package.
+func (this GoServiceConfig) GetMainDir() string {
+	if this.ModuleDir == "" || filepath.IsAbs(this.Main) {
+		return this.Main
+	}
+	return filepath.Join(this.ModuleDir, this.Main)
+}
+
+// validateModuleDir checks module_dir holds a go.mod and main is within it.
+func (this GoServiceConfig) validateModuleDir() error {
+	if this.ModuleDir == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(this.ModuleDir, "go.mod")); err != nil {
+		return errors.Errorf("module_dir %s of %s has no go.mod", this.ModuleDir, this.Name)
+	}
+
+	rel, err := filepath.Rel(this.ModuleDir, this.GetMainDir())
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.Errorf("main %s of %s is outside its module_dir %s", this.Main, this.Name, this.ModuleDir)
+	}
+	return nil
+}
+
+// RepoName returns the repository the service is pushed to, relative to the registry URL.
+// The repo_template config is a Go template over Namespace, Service, Team and Env,
+// defaulting to the namespace and service joined. Empty path segments are dropped.
+func (this GoServiceConfig) RepoName(namespace string) (string, error) {
+	repoTemplate := viper.GetString("repo_template")
+	if repoTemplate == "" {
+		return path.Join(namespace, this.Name), nil
+	}
+
+	tmpl, err := template.New("repo_template").Option("missingkey=error").Parse(repoTemplate)
+	if err != nil {
+		return "", &ConfigError{Err: errors.Wrap(err, "invalid repo_template")}
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]string{
+		"Namespace": namespace,
+		"Service":   this.Name,
+		"Team":      this.Team,
+		"Env":       viper.GetString("env"),
+	})
+	if err != nil {
+		return "", &ConfigError{Err: errors.Wrapf(err, "evaluate repo_template for %s", this.Name)}
+	}
+
+	segments := lo.Compact(strings.Split(out.String(), "/"))
+	if len(segments) == 0 {
+		return "", &ConfigError{Err: errors.Errorf("repo_template evaluates to an empty repository for %s", this.Name)}
+	}
+	return path.Join(segments...), nil
 }
 
+// GetLdflags returns the service's ldflags, or the global ones, still templated.
+func (this GoServiceConfig) GetLdflags() []string {
+	if len(this.Ldflags) > 0 {
+		return this.Ldflags
+	}
+	return viper.GetStringSlice("ldflags")
+}
+
+// GetPlatforms returns the service's platforms, or the global ones.
+func (this GoServiceConfig) GetPlatforms() []string {
+	if len(this.Platforms) > 0 {
+		return this.Platforms
+	}
+	return viper.GetStringSlice("platforms")
+}
+
+// OldImageName returns the image name the manifest rewrites to the pushed image, under
+// the service's old_image_prefix or the global one.
+func (this GoServiceConfig) OldImageName() string {
+	prefix := this.OldImagePrefix
+	if prefix == "" {
+		prefix = viper.GetString("old_image_prefix")
+	}
+	return oldImageName(strings.TrimSuffix(prefix, "/"), this.Name)
+}
+
+// GetTags returns the service's tags, or the global ones.
 func (this GoServiceConfig) GetTags() []string {
 	if this.Tags != nil {
 		return this.Tags
@@ -38,44 +172,324 @@ func (this GoServiceConfig) GetTags() []string {
 	return viper.GetStringSlice("tags")
 }
 
+// baseURLPlaceholder in a base image stands for the URL of the registry released to, so
+// base images mirrored next to the services follow the registry command:
+//
+//	BASE_URL/myorg/base:latest
+//
+// becomes 123456789012.dkr.ecr.us-east-1.amazonaws.com/myorg/base:latest for ecr and
+// registry.okteto.example.com/<namespace>/myorg/base:latest for okteto. $NAME and ${NAME}
+// references to vars or the environment are expanded first, when the config is read.
+const baseURLPlaceholder = "BASE_URL"
+
+// resolveBaseImage replaces the BASE_URL placeholder of baseImage with baseURL.
+func resolveBaseImage(baseImage, baseURL string) string {
+	return strings.ReplaceAll(baseImage, baseURLPlaceholder, baseURL)
+}
+
+// GetBaseImage returns the service's base image, falling back to the base_images entry
+// of the environment selected with --env or IPPON_ENV, then to the global base_image.
 func (this GoServiceConfig) GetBaseImage() string {
 	if this.BaseImage != "" {
 		return this.BaseImage
 	}
 
+	if env := viper.GetString("env"); env != "" {
+		if baseImage, ok := viper.GetStringMapString("base_images")[strings.ToLower(env)]; ok {
+			return baseImage
+		}
+	}
+
 	return viper.GetString("base_image")
 }
 
-func getConfig(registryName, path string) (*Config, error) {
-	f, err := os.Open(path)
+// GetPlatformBaseImages returns the base image per platform, if any. A service's own
+// base_image takes precedence over the global platform_base_images.
+func (this GoServiceConfig) GetPlatformBaseImages() map[string]string {
+	if len(this.PlatformBaseImages) > 0 {
+		return this.PlatformBaseImages
+	}
+	if this.BaseImage != "" {
+		return nil
+	}
+	return viper.GetStringMapString("platform_base_images")
+}
+
+// GetIndexAnnotations merges the global and service annotations targeting the index,
+// the service ones winning.
+func (this GoServiceConfig) GetIndexAnnotations() map[string]string {
+	return lo.Assign(
+		viper.GetStringMapString("annotations"),
+		viper.GetStringMapString("index_annotations"),
+		this.Annotations,
+		this.IndexAnnotations,
+	)
+}
+
+// GetManifestAnnotations merges the global and service annotations targeting each
+// platform manifest, the service ones winning.
+func (this GoServiceConfig) GetManifestAnnotations() map[string]string {
+	return lo.Assign(
+		viper.GetStringMapString("manifest_annotations"),
+		this.ManifestAnnotations,
+	)
+}
+
+// getServicesConfig reads the config files and returns their services, without
+// touching any registry. Settings come from the first file, the go_services of the
+// others are appended to its own.
+func getServicesConfig(configPaths []string) (*ServicesConfig, error) {
+	paths, err := expandConfigPaths(configPaths)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	f, err := os.Open(paths[0])
 	if err != nil {
-		return nil, errors.Wrap(err, "failed opening config file")
+		return nil, &ConfigError{Err: errors.Wrap(err, "failed opening config file")}
 	}
 	defer f.Close()
 
 	err = viper.ReadConfig(f)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed reading config file")
+		return nil, &ConfigError{Err: errors.Wrap(err, "failed reading config file")}
+	}
+
+	err = expandConfig()
+	if err != nil {
+		return nil, &ConfigError{Err: errors.Wrapf(err, "config file %s", paths[0])}
+	}
+
+	if !viper.GetBool("lax_config") {
+		err = checkConfigKeys(registrySections)
+		if err != nil {
+			return nil, &ConfigError{Err: errors.Wrap(err, "invalid config file, use --lax-config to ignore")}
+		}
 	}
 
 	var services ServicesConfig
 	err = viper.Unmarshal(&services)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed unmarshalling config file")
+		return nil, &ConfigError{Err: errors.Wrap(err, "failed unmarshalling config file")}
+	}
+
+	origins := lo.SliceToMap(services.GoServices, func(s GoServiceConfig) (string, string) {
+		return s.Name, paths[0]
+	})
+	for _, path := range paths[1:] {
+		merged, err := readServicesFile(path)
+		if err != nil {
+			return nil, &ConfigError{Err: errors.Wrapf(err, "config file %s", path)}
+		}
+		for _, service := range merged {
+			if origin, ok := origins[service.Name]; ok {
+				return nil, &ConfigError{Err: errors.Errorf("service %s is defined in both %s and %s", service.Name, origin, path)}
+			}
+			origins[service.Name] = path
+			services.GoServices = append(services.GoServices, service)
+		}
+	}
+
+	fromFiles, err := readServiceFiles(viper.GetStringSlice("service_files"))
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	for _, service := range fromFiles {
+		if lo.ContainsBy(services.GoServices, func(s GoServiceConfig) bool { return s.Name == service.Name }) {
+			return nil, &ConfigError{Err: errors.Errorf("service %s is defined more than once", service.Name)}
+		}
+		services.GoServices = append(services.GoServices, service)
+	}
+
+	return &services, nil
+}
+
+// Validate reports every problem of the services, rather than only the first one.
+func (this *ServicesConfig) Validate() []error {
+	problems := []error{}
+	seen := map[string]bool{}
+	for i, service := range this.GoServices {
+		if service.Name == "" {
+			problems = append(problems, errors.Errorf("go_services entry %d has no name", i))
+		} else if seen[service.Name] {
+			problems = append(problems, errors.Errorf("service %s is defined more than once", service.Name))
+		}
+		seen[service.Name] = true
+
+		if service.Main == "" {
+			problems = append(problems, errors.Errorf("service %s has no main", service.Name))
+		} else if info, err := os.Stat(service.GetMainDir()); err != nil || !info.IsDir() {
+			problems = append(problems, errors.Errorf("main %s of %s is not a directory", service.GetMainDir(), service.Name))
+		}
+
+		if err := service.validateModuleDir(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// Validate checks the services and the registry settings before any work starts,
+// returning every problem found as a single error.
+func (this *Config) Validate(registryName string) error {
+	problems := this.ServicesConfig.Validate()
+	if this.ECR != nil {
+		section := registrySection(registryName)
+		if this.ECR.AccountId() == "" {
+			problems = append(problems, errors.Errorf("%s.account is not set", section))
+		}
+		if this.ECR.Region() == "" {
+			problems = append(problems, errors.Errorf("%s.region is not set", section))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Err: errors.Wrap(aggregateErrors(problems), "invalid config")}
+	}
+	return nil
+}
+
+// checkRequiredEnv reports every environment variable the registry needs that is unset,
+// before any work starts.
+func checkRequiredEnv(reg Registry) error {
+	envRegistry, ok := reg.(EnvRegistry)
+	if !ok {
+		return nil
+	}
+
+	missing := []string{}
+	for _, alternatives := range envRegistry.RequiredEnv() {
+		set := lo.ContainsBy(alternatives, func(name string) bool {
+			_, ok := os.LookupEnv(name)
+			return ok
+		})
+		if !set {
+			missing = append(missing, strings.Join(alternatives, " or "))
+		}
+	}
+
+	if len(missing) > 0 {
+		return &ConfigError{Err: errors.Errorf("missing environment variables: %s", strings.Join(missing, ", "))}
+	}
+	return nil
+}
+
+// getRegistrylessConfig reads the config file and loads the plugins, leaving the
+// registry for the caller to set.
+func getRegistrylessConfig(paths []string) (*Config, error) {
+	services, err := getServicesConfig(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.LoadPlugins(viper.GetStringSlice("plugins"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		ServicesConfig: services,
+		Keychains:      viper.GetStringSlice("keychains"),
+		Builder:        viper.GetString("builder"),
+		Publisher:      viper.GetString("publisher"),
+	}, nil
+}
+
+// registrySection returns the config section the registry command reads: its own name,
+// or for ecr the prod section of older configs when there is no ecr section.
+func registrySection(registryName string) string {
+	if registryName == ecrRegistryName && !viper.IsSet(ecrRegistryName) && viper.IsSet(prodRegistryName) {
+		return prodRegistryName
+	}
+	return registryName
+}
+
+func getConfig(registryName string, paths []string) (*Config, error) {
+	config, err := getRegistrylessConfig(paths)
+	if err != nil {
+		return nil, err
 	}
 
-	accountID := viper.GetString(registryName + ".account")
-	region := viper.GetString(registryName + ".region")
 	ctx := context.Background()
-	ecr, err := registry.NewECR(ctx, accountID, region)
+	if registryName == gcrRegistryName {
+		gcr, err := registry.NewArtifactRegistry(ctx,
+			viper.GetString(registryName+".project"),
+			viper.GetString(registryName+".location"),
+			viper.GetString(registryName+".repository"),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed initializing Artifact Registry")
+		}
+		config.Registry = gcr
+		return config, nil
+	}
+
+	if registryName == ghcrRegistryName {
+		err = checkRequiredEnv(&registry.GHCR{})
+		if err != nil {
+			return nil, err
+		}
+		ghcr, err := registry.NewGHCR(ctx, viper.GetString(registryName+".owner"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed initializing ghcr registry")
+		}
+		config.Registry = ghcr
+		return config, nil
+	}
+
+	if registryName == dockerHubRegistryName {
+		err = checkRequiredEnv(&registry.DockerHub{})
+		if err != nil {
+			return nil, err
+		}
+		dockerHub, err := registry.NewDockerHub(ctx, viper.GetString(registryName+".namespace"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed initializing Docker Hub registry")
+		}
+		config.Registry = dockerHub
+		return config, nil
+	}
+
+	section := registrySection(registryName)
+	accountID := viper.GetString(section + ".account")
+	// okteto uses its own registry unless an ECR account is configured for it
+	if registryName == oktetoRegistryName && accountID == "" {
+		okteto := &registry.Okteto{}
+		err = checkRequiredEnv(okteto)
+		if err != nil {
+			return nil, err
+		}
+		err = okteto.Init(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed initializing Okteto registry")
+		}
+		config.Registry = okteto
+		return config, nil
+	}
+
+	region := viper.GetString(section + ".region")
+	ecr, err := registry.NewECR(ctx, accountID, region, viper.GetString(section+".role_arn"))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed creating ECR client")
 	}
-
-	config := &Config{
-		ECR:            ecr,
-		ServicesConfig: &services,
+	createOptions := registry.CreateRepositoryOptions{
+		ImmutableTags:  viper.GetBool(section + ".immutable_tags"),
+		ScanOnPush:     viper.GetBool(section + ".scan_on_push"),
+		EncryptionType: strings.ToUpper(viper.GetString(section + ".encryption_type")),
+		KMSKey:         viper.GetString(section + ".kms_key"),
+	}
+	if err := createOptions.Validate(); err != nil {
+		return nil, &ConfigError{Err: errors.Wrapf(err, "invalid %s config", section)}
+	}
+	ecr.SetCreateRepositoryOptions(createOptions)
+	// a string, since viper lowercases the keys of nested maps
+	policy := viper.GetString(section + ".repository_policy")
+	if policy != "" && !json.Valid([]byte(policy)) {
+		return nil, &ConfigError{Err: errors.Errorf("%s.repository_policy is not a valid JSON document", section)}
 	}
+	ecr.SetRepositoryPolicy(policy)
+	config.Registry = ecr
+	config.ECR = ecr
 
 	return config, nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lema-ai/ippon/registry"
+)
+
+func TestResolveBaseImage(t *testing.T) {
+	ecr, err := registry.NewECR(context.Background(), "123456789012", "us-east-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OKTETO_REGISTRY_URL", "registry.okteto.example.com")
+	t.Setenv("OKTETO_NAMESPACE", "dev")
+	t.Setenv("OKTETO_USERNAME", "ippon")
+	t.Setenv("OKTETO_TOKEN", "token")
+	okteto := &registry.Okteto{}
+	if err := okteto.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		registry  Registry
+		baseImage string
+		want      string
+	}{
+		{
+			name:      "ecr",
+			registry:  ecr,
+			baseImage: "BASE_URL/myorg/base:latest",
+			want:      "123456789012.dkr.ecr.us-east-1.amazonaws.com/myorg/base:latest",
+		},
+		{
+			name:      "okteto",
+			registry:  okteto,
+			baseImage: "BASE_URL/myorg/base:latest",
+			want:      "registry.okteto.example.com/dev/myorg/base:latest",
+		},
+		{
+			name:      "no placeholder",
+			registry:  ecr,
+			baseImage: "cgr.dev/chainguard/static:latest",
+			want:      "cgr.dev/chainguard/static:latest",
+		},
+		{
+			name:      "default base image",
+			registry:  okteto,
+			baseImage: defaultBaseImage,
+			want:      defaultBaseImage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := resolveBaseImage(test.baseImage, test.registry.URL())
+			if got != test.want {
+				t.Errorf("base image %s, want %s", got, test.want)
+			}
+		})
+	}
+}
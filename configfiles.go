@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// expandConfigPaths resolves the --config values, each a file, a glob or a directory
+// whose .yaml and .yml files are read in name order, keeping the order of the values.
+func expandConfigPaths(values []string) ([]string, error) {
+	paths := []string{}
+	for _, value := range values {
+		if info, err := os.Stat(value); err == nil && info.IsDir() {
+			matches := []string{}
+			for _, ext := range []string{"*.yaml", "*.yml"} {
+				found, err := filepath.Glob(filepath.Join(value, ext))
+				if err != nil {
+					return nil, err
+				}
+				matches = append(matches, found...)
+			}
+			sort.Strings(matches)
+			if len(matches) == 0 {
+				return nil, errors.Errorf("config directory %s has no YAML files", value)
+			}
+			paths = append(paths, matches...)
+			continue
+		}
+
+		matches, err := filepath.Glob(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid config pattern %q", value)
+		}
+		// a missing file is reported when opening it
+		if len(matches) == 0 {
+			matches = []string{value}
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+
+	paths = lo.Uniq(paths)
+	if len(paths) == 0 {
+		return nil, errors.New("no config file")
+	}
+	return paths, nil
+}
+
+// decodeConfig decodes a config document read outside of viper with the decoder config
+// viper unmarshals with, weakly typed and splitting comma separated strings into lists,
+// failing on unknown keys unless --lax-config.
+func decodeConfig(raw map[string]interface{}, result interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused:      !viper.GetBool("lax_config"),
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		Result: result,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(raw)
+}
+
+// readServicesFile reads the go_services of a config file merged into the first one,
+// which can't hold anything else: registry and global settings come from the first file.
+func readServicesFile(path string) ([]GoServiceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	raw, err = expandRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var services ServicesConfig
+	err = decodeConfig(raw, &services)
+	if err != nil {
+		return nil, errors.Wrap(err, "only go_services can be merged from other config files")
+	}
+
+	return services.GoServices, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/ko/pkg/build"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const debugTagSuffix = "-debug"
+
+// withDebugEntrypoint replaces the Go binary entrypoint by shell, so the container can be
+// investigated with kubectl exec/debug. The base image must contain shell, the binary
+// itself stays in the image at its usual path.
+func withDebugEntrypoint(r build.Result, shell string) (build.Result, error) {
+	setEntrypoint := func(img v1.Image) (v1.Image, error) {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return nil, errors.Wrap(err, "get image config")
+		}
+		cfg = cfg.DeepCopy()
+		cfg.Config.Entrypoint = []string{shell}
+		cfg.Config.Cmd = nil
+		return mutate.ConfigFile(img, cfg)
+	}
+
+	if idx, ok := r.(v1.ImageIndex); ok {
+		return mapIndexImages(idx, setEntrypoint)
+	}
+	if img, ok := r.(v1.Image); ok {
+		return setEntrypoint(img)
+	}
+	return nil, errors.Errorf("unexpected build result %T", r)
+}
+
+// debugTags labels every tag of a debug build so it can't be mistaken for a regular image.
+func debugTags(tags []string) []string {
+	return lo.Map(tags, func(tag string, _ int) string {
+		return tag + debugTagSuffix
+	})
+}
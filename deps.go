@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	syncWaveHints = "sync-wave"
+	commentHints  = "comment"
+
+	syncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+)
+
+// serviceWaves assigns every service the wave it can roll out in: 0 without depends_on,
+// one more than its latest dependency otherwise.
+func serviceWaves(services []GoServiceConfig) (map[string]int, error) {
+	byName := lo.SliceToMap(services, func(s GoServiceConfig) (string, GoServiceConfig) {
+		return s.Name, s
+	})
+
+	waves := map[string]int{}
+	visiting := map[string]bool{}
+	var visit func(name string) (int, error)
+	visit = func(name string) (int, error) {
+		if wave, ok := waves[name]; ok {
+			return wave, nil
+		}
+		if visiting[name] {
+			return 0, errors.Errorf("dependency cycle through %s", name)
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		wave := 0
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return 0, errors.Errorf("%s depends on unknown service %s", name, dep)
+			}
+			depWave, err := visit(dep)
+			if err != nil {
+				return 0, err
+			}
+			wave = max(wave, depWave+1)
+		}
+		waves[name] = wave
+		return wave, nil
+	}
+
+	for _, service := range services {
+		if _, err := visit(service.Name); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+	return waves, nil
+}
+
+// groupWaves groups services by the wave serviceWaves assigns them, keeping their order
+// within a wave. Dependencies on services left out of the release are ignored.
+func groupWaves(services []GoServiceConfig) ([][]GoServiceConfig, error) {
+	names := lo.Map(services, func(s GoServiceConfig, _ int) string { return s.Name })
+	released := lo.Map(services, func(s GoServiceConfig, _ int) GoServiceConfig {
+		s.DependsOn = lo.Intersect(s.DependsOn, names)
+		return s
+	})
+	waves, err := serviceWaves(released)
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := [][]GoServiceConfig{}
+	for _, service := range services {
+		wave := waves[service.Name]
+		for len(grouped) <= wave {
+			grouped = append(grouped, []GoServiceConfig{})
+		}
+		grouped[wave] = append(grouped[wave], service)
+	}
+	return grouped, nil
+}
+
+// orderHints returns a function ordering manifest entries by wave, dependencies first, and
+// marking each with its wave as an Argo CD sync-wave annotation or a comment. waves is
+// keyed by old image name, entries of unknown services are left in wave 0.
+func orderHints(format string, waves map[string]int) (func(*Images), error) {
+	if format != syncWaveHints && format != commentHints {
+		return nil, errors.Errorf("unknown manifest order hints %q, expected %s or %s", format, syncWaveHints, commentHints)
+	}
+
+	return func(images *Images) {
+		// entries may be shared between namespaces, hint copies of them
+		images.Images = lo.Map(images.Images, func(image *Image, _ int) *Image {
+			hinted := *image
+			wave := strconv.Itoa(waves[image.OldName])
+			if format == syncWaveHints {
+				hinted.Annotations = lo.Assign(image.Annotations, map[string]string{syncWaveAnnotation: wave})
+			} else {
+				hinted.comment = "wave " + wave
+			}
+			return &hinted
+		})
+		sort.SliceStable(images.Images, func(i, j int) bool {
+			return waves[images.Images[i].OldName] < waves[images.Images[j].OldName]
+		})
+	}, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// digestTagHex tags images with the bare hex of their digest
+	digestTagHex = "hex"
+	// digestTagPrefixed tags images with sha256-<hex>, the form cosign uses for its tags
+	digestTagPrefixed = "sha256-prefixed"
+)
+
+func validateDigestTagFormat(format string) error {
+	switch format {
+	case "", digestTagHex, digestTagPrefixed:
+		return nil
+	default:
+		return &ConfigError{Err: errors.Errorf("unknown digest_tag %q, expected %s or %s", format, digestTagHex, digestTagPrefixed)}
+	}
+}
+
+// digestTag returns the tag derived from digest in format, or "" when format is empty.
+func digestTag(format string, digest v1.Hash) string {
+	switch format {
+	case digestTagHex:
+		return digest.Hex
+	case digestTagPrefixed:
+		return digest.Algorithm + "-" + digest.Hex
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http/httptest"
+	"strings"
+
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+)
+
+// ephemeralRegistry is an in-memory registry served for the duration of a release, for
+// hermetic runs that verify the whole build, push and manifest flow.
+type ephemeralRegistry struct {
+	server *httptest.Server
+}
+
+func newEphemeralRegistry() *ephemeralRegistry {
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.Default())))
+	log.Printf("ippon serving ephemeral registry at %s\n", server.URL)
+	return &ephemeralRegistry{server: server}
+}
+
+func (this *ephemeralRegistry) Init(context.Context) error {
+	return nil
+}
+
+// URL returns the registry host, go-containerregistry talks plain HTTP to local hosts.
+func (this *ephemeralRegistry) URL() string {
+	return strings.TrimPrefix(this.server.URL, "http://")
+}
+
+func (this *ephemeralRegistry) Close() {
+	this.server.Close()
+}
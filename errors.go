@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Typed errors let callers tell failure kinds apart with errors.As, their messages are
+// those of the wrapped errors.
+
+// ConfigError is returned when the config file can't be read or is invalid.
+type ConfigError struct {
+	Err error
+}
+
+func (this *ConfigError) Error() string { return this.Err.Error() }
+func (this *ConfigError) Unwrap() error { return this.Err }
+
+// BuildError is returned when building a service's image fails.
+type BuildError struct {
+	Service string
+	Err     error
+}
+
+func (this *BuildError) Error() string { return this.Service + ": " + this.Err.Error() }
+func (this *BuildError) Unwrap() error { return this.Err }
+
+// PublishError is returned when pushing a service's image fails. Tags tells which tags
+// landed when the failure happened while tagging.
+type PublishError struct {
+	Service string
+	Err     error
+	Tags    []TagResult
+}
+
+func (this *PublishError) Error() string { return this.Service + ": " + this.Err.Error() }
+func (this *PublishError) Unwrap() error { return this.Err }
+
+// RegistryAuthError is returned when no credentials can be obtained for a registry.
+type RegistryAuthError struct {
+	Registry string
+	Err      error
+}
+
+func (this *RegistryAuthError) Error() string { return this.Registry + ": " + this.Err.Error() }
+func (this *RegistryAuthError) Unwrap() error { return this.Err }
+
+// ServiceFailure is a service that failed to release.
+type ServiceFailure struct {
+	Service string
+	Err     error
+}
+
+// ReleaseError is returned when services failed to release, listing every one of them
+// rather than the first. errors.As finds the typed errors of each failure.
+type ReleaseError struct {
+	Failures []ServiceFailure
+	// Services is the number of services the release built
+	Services int
+}
+
+func (this *ReleaseError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d services failed:", len(this.Failures), this.Services)
+	for _, failure := range this.Failures {
+		fmt.Fprintf(&b, "\n  %s: %v", failure.Service, failure.Err)
+	}
+	return b.String()
+}
+
+func (this *ReleaseError) Unwrap() []error {
+	return lo.Map(this.Failures, func(failure ServiceFailure, _ int) error {
+		return failure.Err
+	})
+}
+
+// Exit codes of the typed errors, 1 being any other failure.
+const (
+	exitConfig       = 2
+	exitRegistryAuth = 3
+	exitBuild        = 4
+	exitPublish      = 5
+)
+
+// exitCode maps err to the process exit code, looking for the typed errors through the
+// wrap chain. A release whose services failed in different ways exits with the code
+// listed first.
+func exitCode(err error) int {
+	var configErr *ConfigError
+	var authErr *RegistryAuthError
+	var buildErr *BuildError
+	var publishErr *PublishError
+	switch {
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &authErr):
+		return exitRegistryAuth
+	case errors.As(err, &buildErr):
+		return exitBuild
+	case errors.As(err, &publishErr):
+		return exitPublish
+	default:
+		return 1
+	}
+}
+
+// aggregateErrors returns nil, the only error, or an error listing all of them.
+func aggregateErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		msgs := lo.Map(errs, func(err error, _ int) string {
+			return err.Error()
+		})
+		return errors.Errorf("%d failures: %s", len(errs), strings.Join(msgs, "; "))
+	}
+}
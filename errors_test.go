@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestTypedErrorsThroughWrapChain(t *testing.T) {
+	cause := errors.New("boom")
+	tests := []struct {
+		name     string
+		err      error
+		find     func(error) bool
+		exitCode int
+	}{
+		{
+			name: "config",
+			err:  errors.Wrap(&ConfigError{Err: cause}, "get services config"),
+			find: func(err error) bool {
+				var target *ConfigError
+				return errors.As(err, &target) && target.Err == cause
+			},
+			exitCode: exitConfig,
+		},
+		{
+			name: "registry auth",
+			err:  fmt.Errorf("init registry: %w", errors.Wrap(&RegistryAuthError{Registry: "ecr", Err: cause}, "get credentials")),
+			find: func(err error) bool {
+				var target *RegistryAuthError
+				return errors.As(err, &target) && target.Registry == "ecr"
+			},
+			exitCode: exitRegistryAuth,
+		},
+		{
+			name: "build",
+			err:  errors.Wrap(&BuildError{Service: "api", Err: cause}, "release api"),
+			find: func(err error) bool {
+				var target *BuildError
+				return errors.As(err, &target) && target.Service == "api"
+			},
+			exitCode: exitBuild,
+		},
+		{
+			name: "publish",
+			err:  errors.Wrap(&PublishError{Service: "web", Err: cause}, "release web"),
+			find: func(err error) bool {
+				var target *PublishError
+				return errors.As(err, &target) && target.Service == "web"
+			},
+			exitCode: exitPublish,
+		},
+		{
+			name: "release of one failed build",
+			err: errors.Wrap(&ReleaseError{Services: 3, Failures: []ServiceFailure{
+				{Service: "api", Err: errors.Wrap(&BuildError{Service: "api", Err: cause}, "build")},
+			}}, "release"),
+			find: func(err error) bool {
+				var release *ReleaseError
+				var build *BuildError
+				return errors.As(err, &release) && errors.As(err, &build) && build.Service == "api"
+			},
+			exitCode: exitBuild,
+		},
+		{
+			name: "release of a failed build and a failed publish",
+			err: &ReleaseError{Services: 3, Failures: []ServiceFailure{
+				{Service: "web", Err: &PublishError{Service: "web", Err: cause}},
+				{Service: "api", Err: &BuildError{Service: "api", Err: cause}},
+			}},
+			find: func(err error) bool {
+				var build *BuildError
+				var publish *PublishError
+				return errors.As(err, &build) && errors.As(err, &publish)
+			},
+			exitCode: exitBuild,
+		},
+		{
+			name: "untyped",
+			err:  errors.Wrap(cause, "failed getting config flag"),
+			find: func(err error) bool {
+				return errors.Is(err, cause)
+			},
+			exitCode: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.find(test.err) {
+				t.Errorf("typed error not found in %v", test.err)
+			}
+			if got := exitCode(test.err); got != test.exitCode {
+				t.Errorf("exit code %d, want %d", got, test.exitCode)
+			}
+		})
+	}
+}
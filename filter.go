@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+type excludedServicesFile struct {
+	ExcludedServices []string `yaml:"excluded_services"`
+}
+
+func readExcludedServices(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading excluded services file")
+	}
+
+	var f excludedServicesFile
+	err = yaml.Unmarshal(data, &f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed unmarshalling excluded services file")
+	}
+
+	return f.ExcludedServices, nil
+}
+
+// readReleaseSets reads a file mapping release set names to the services they release.
+func readReleaseSets(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading release sets file")
+	}
+
+	var sets map[string][]string
+	err = yaml.Unmarshal(data, &sets)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed unmarshalling release sets file")
+	}
+
+	return sets, nil
+}
+
+// getReleaseSetServices returns the services of the named release sets, failing on unknown
+// sets and on sets referencing services missing from the config.
+func getReleaseSetServices(path string, names []string, services []GoServiceConfig) ([]string, error) {
+	sets, err := readReleaseSets(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configured := lo.Map(services, func(s GoServiceConfig, _ int) string {
+		return s.Name
+	})
+
+	selected := []string{}
+	for _, name := range names {
+		set, ok := sets[name]
+		if !ok {
+			return nil, errors.Errorf("unknown release set %q in %s", name, path)
+		}
+		unknown := lo.Without(set, configured...)
+		if len(unknown) > 0 {
+			return nil, errors.Errorf("release set %q references unknown services: %s", name, strings.Join(unknown, ", "))
+		}
+		selected = append(selected, set...)
+	}
+
+	return lo.Uniq(selected), nil
+}
+
+// checkServicesExist fails on names missing from the configured services.
+func checkServicesExist(services []GoServiceConfig, names []string) error {
+	configured := lo.Map(services, func(s GoServiceConfig, _ int) string {
+		return s.Name
+	})
+	unknown := lo.Without(names, configured...)
+	if len(unknown) > 0 {
+		return errors.Errorf("unknown services: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// getExcludedServices returns the union of the services excluded inline in the config,
+// under excluded_services or exclude, in the excluded services file and with the
+// --exclude flag.
+func getExcludedServices(cmd *cobra.Command) ([]string, error) {
+	excluded := append(viper.GetStringSlice("excluded_services"), viper.GetStringSlice("exclude")...)
+
+	excludedFile, err := cmd.Flags().GetString("excluded-services-file")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting excluded-services-file flag")
+	}
+	if excludedFile != "" {
+		fromFile, err := readExcludedServices(excludedFile)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, fromFile...)
+	}
+
+	fromFlag, err := cmd.Flags().GetStringSlice("exclude")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting exclude flag")
+	}
+	excluded = append(excluded, fromFlag...)
+
+	return lo.Uniq(excluded), nil
+}
+
+// matchServices keeps the services whose name matches pattern, a glob (payments-*) or a
+// regular expression between slashes (/^payments-(api|worker)$/).
+func matchServices(services []GoServiceConfig, pattern string) ([]GoServiceConfig, error) {
+	var match func(name string) (bool, error)
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid match regex")
+		}
+		match = func(name string) (bool, error) {
+			return re.MatchString(name), nil
+		}
+	} else {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrap(err, "invalid match glob")
+		}
+		match = func(name string) (bool, error) {
+			return path.Match(pattern, name)
+		}
+	}
+
+	matched := []GoServiceConfig{}
+	for _, service := range services {
+		ok, err := match(service.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, service)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, errors.Errorf("no service matches %q", pattern)
+	}
+	return matched, nil
+}
+
+func filterServices(services []GoServiceConfig, excluded []string) []GoServiceConfig {
+	return lo.Filter(services, func(s GoServiceConfig, _ int) bool {
+		if lo.Contains(excluded, s.Name) {
+			log.Printf("ippon skipping excluded service: %s\n", s.Name)
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s", strings.Join(args, " "))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitTreeDirty reports whether the working tree has uncommitted changes, untracked files
+// included.
+func gitTreeDirty(ctx context.Context) (bool, error) {
+	status, err := runGit(ctx, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return status != "", nil
+}
+
+func currentGitBranch(ctx context.Context) (string, error) {
+	branch, err := runGit(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if branch == "HEAD" {
+		return "", errors.New("git HEAD is detached")
+	}
+	return branch, nil
+}
+
+const maxTagLength = 128
+
+var invalidTagChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// branchTag turns a branch name into a valid tag: it is lowercased, every run of
+// characters other than letters, digits, '_', '.' and '-' becomes a single '-', leading
+// '.' and '-' are dropped since tags can't start with them, and the result is cut to 128
+// characters. feature/JIRA-12_Login becomes feature-jira-12_login.
+func branchTag(branch string) (string, error) {
+	tag := invalidTagChars.ReplaceAllString(strings.ToLower(branch), "-")
+	tag = strings.TrimLeft(tag, ".-")
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	if tag == "" {
+		return "", errors.Errorf("branch %q has no characters usable in a tag", branch)
+	}
+	return tag, nil
+}
+
+// semverTag matches semver git tags without build metadata, as image tags can't hold a +.
+var semverTag = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+
+// dirtyTag appends suffix to tag, cutting tag so the result stays within 128 characters.
+func dirtyTag(tag, suffix string) string {
+	if suffix == "" {
+		return tag
+	}
+	return tag[:min(len(tag), maxTagLength-len(suffix))] + suffix
+}
+
+// gitTags derives tags from the checked out commit: its short SHA, the branch as a tag, and
+// the semver tag HEAD is tagged with, if any. Each gets dirtySuffix, as the images of a
+// dirty tree don't match the commit.
+func gitTags(ctx context.Context, branch, dirtySuffix string) ([]string, error) {
+	commit, err := runGit(ctx, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{commit}
+
+	if branch == "" {
+		branch, _ = currentGitBranch(ctx)
+	}
+	if branch != "" {
+		if tag, err := branchTag(branch); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	if version, err := runGit(ctx, "describe", "--tags", "--exact-match", "HEAD"); err == nil {
+		if semverTag.MatchString(version) {
+			tags = append(tags, version)
+		}
+	}
+	return lo.Map(tags, func(tag string, _ int) string {
+		return dirtyTag(tag, dirtySuffix)
+	}), nil
+}
+
+// gitAnnotations returns the OCI revision, source, created and version annotations of the
+// checked out commit. Outside of a git repository it returns none. The version is only set
+// when HEAD is tagged, and credentials are stripped from the origin URL.
+func gitAnnotations(ctx context.Context) map[string]string {
+	revision, err := runGit(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		log.Printf("ippon skipping git annotations: %v\n", err)
+		return nil
+	}
+
+	annotations := map[string]string{
+		"org.opencontainers.image.revision": revision,
+	}
+	if source, err := runGit(ctx, "remote", "get-url", "origin"); err == nil {
+		if u, err := url.Parse(source); err == nil && u.User != nil {
+			u.User = nil
+			source = u.String()
+		}
+		annotations["org.opencontainers.image.source"] = source
+	}
+	if created, err := runGit(ctx, "show", "-s", "--format=%cI", "HEAD"); err == nil {
+		annotations["org.opencontainers.image.created"] = created
+	}
+	if version, err := runGit(ctx, "describe", "--tags", "--exact-match", "HEAD"); err == nil {
+		annotations["org.opencontainers.image.version"] = version
+	}
+	return annotations
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDirtyTag(t *testing.T) {
+	long := strings.Repeat("a", maxTagLength)
+	tests := []struct {
+		name   string
+		tag    string
+		suffix string
+		want   string
+	}{
+		{name: "clean", tag: "main", want: "main"},
+		{name: "dirty", tag: "main", suffix: "-dirty", want: "main-dirty"},
+		{name: "cut to the tag length", tag: long, suffix: "-dirty", want: long[:maxTagLength-len("-dirty")] + "-dirty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := dirtyTag(test.tag, test.suffix); got != test.want {
+				t.Errorf("tag %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGitTagsDirtySuffix(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=ippon", "-c", "user.email=ippon@lema.ai"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	git("tag", "v1.2.3")
+	commit := git("rev-parse", "--short", "HEAD")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	tests := []struct {
+		name   string
+		suffix string
+		want   []string
+	}{
+		{name: "clean", want: []string{commit, "main", "v1.2.3"}},
+		{name: "dirty", suffix: "-dirty", want: []string{commit + "-dirty", "main-dirty", "v1.2.3-dirty"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags, err := gitTags(context.Background(), "", test.suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(tags, test.want) {
+				t.Errorf("tags %v, want %v", tags, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// headerTransport adds fixed headers to every registry request, on top of (not instead of)
+// the registry auth, for registries fronted by header based gateways.
+type headerTransport struct {
+	headers http.Header
+	inner   http.RoundTripper
+}
+
+func (this *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range this.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return this.inner.RoundTrip(req)
+}
+
+// parseRegistryHeaders merges the registry_headers config map with Key=Value flag values.
+func parseRegistryHeaders(fromConfig map[string]string, fromFlags []string) (http.Header, error) {
+	headers := http.Header{}
+	for key, value := range fromConfig {
+		headers.Add(key, value)
+	}
+
+	for _, header := range fromFlags {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid registry header %q, expected Key=Value", header)
+		}
+		headers.Add(key, value)
+	}
+
+	return headers, nil
+}
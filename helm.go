@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+)
+
+const (
+	manifestDeploymentFormat = "manifest"
+	helmDeploymentFormat     = "helm"
+)
+
+// HelmValuesConfig is the values file --deployment-format helm updates, and where the
+// image of each service is in it.
+type HelmValuesConfig struct {
+	File     string              `mapstructure:"file"`
+	Services []HelmServiceValues `mapstructure:"services"`
+}
+
+// HelmServiceValues holds the dotted paths of a service's image values, such as
+// inventory.image.repository. Paths left empty aren't set, Tag getting the first tag.
+type HelmServiceValues struct {
+	Name       string `mapstructure:"name"`
+	Repository string `mapstructure:"repository"`
+	Tag        string `mapstructure:"tag"`
+	Digest     string `mapstructure:"digest"`
+}
+
+func getHelmValuesConfig() (*HelmValuesConfig, error) {
+	var config HelmValuesConfig
+	err := viper.UnmarshalKey("helm_values", &config)
+	if err != nil {
+		return nil, &ConfigError{Err: errors.Wrap(err, "failed unmarshalling helm_values")}
+	}
+	if config.File == "" {
+		return nil, &ConfigError{Err: errors.New("--deployment-format helm needs helm_values.file in config")}
+	}
+	return &config, nil
+}
+
+// yqPath turns a dotted values path into a yq path, quoting every key so keys holding
+// dashes work: inventory.image.tag becomes .["inventory"]["image"]["tag"].
+func yqPath(dotted string) string {
+	keys := lo.Map(strings.Split(dotted, "."), func(key string, _ int) string {
+		return "[" + strconv.Quote(key) + "]"
+	})
+	return "." + strings.Join(keys, "")
+}
+
+// helmValuesExpression is the yq expression setting the configured values of every
+// released service, or an empty one when none of them is mapped.
+func helmValuesExpression(config *HelmValuesConfig, results []*ServiceResult) (string, error) {
+	byName := lo.KeyBy(results, func(r *ServiceResult) string {
+		return r.Service
+	})
+
+	updates := []string{}
+	set := func(path, value string) {
+		if path != "" {
+			updates = append(updates, fmt.Sprintf("%s = %s", yqPath(path), strconv.Quote(value)))
+		}
+	}
+	for _, service := range config.Services {
+		result, ok := byName[service.Name]
+		if !ok {
+			continue
+		}
+
+		repository, digest, ok := strings.Cut(result.Image.NewName, "@")
+		if !ok {
+			return "", errors.Errorf("image %s has no digest", result.Image.NewName)
+		}
+		set(service.Repository, repository)
+		set(service.Digest, digest)
+		if len(result.Tags) > 0 {
+			set(service.Tag, result.Tags[0])
+		}
+	}
+
+	unmapped := lo.Without(lo.Keys(byName), lo.Map(config.Services, func(s HelmServiceValues, _ int) string {
+		return s.Name
+	})...)
+	if len(unmapped) > 0 {
+		log.Printf("ippon no helm values for %s\n", strings.Join(unmapped, ", "))
+	}
+	return strings.Join(updates, " | "), nil
+}
+
+// updateHelmValues points the images of the released services at their pushed digests in
+// the values file, editing it in place so comments and ordering are kept.
+func updateHelmValues(config *HelmValuesConfig, results []*ServiceResult) error {
+	if _, err := os.Stat(config.File); err != nil {
+		return errors.Wrap(err, "read helm values")
+	}
+
+	expression, err := helmValuesExpression(config, results)
+	if err != nil || expression == "" {
+		return err
+	}
+	return errors.Wrap(transformKustomization(config.File, expression), "update helm values")
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	yqcmd "github.com/mikefarah/yq/v4/cmd"
+	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 type Images struct {
@@ -13,8 +22,24 @@ type Images struct {
 }
 
 type Image struct {
-	OldName string `yaml:"old_image"`
-	NewName string `yaml:"new_image"`
+	OldName     string            `yaml:"old_image"`
+	NewName     string            `yaml:"new_image"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// comment is written above the entry
+	comment string
+}
+
+const defaultOldImagePrefix = "registry.lema.ai"
+
+func oldImageName(prefix, serviceName string) string {
+	return fmt.Sprintf("%s/%s", prefix, serviceName)
+}
+
+const defaultImagesOutputDir = ".ippon"
+
+// kustomizationPath returns the manifest of namespace, <images_output_dir>/<namespace>.yaml.
+func kustomizationPath(namespace string) string {
+	return path.Join(viper.GetString("images_output_dir"), namespace+".yaml")
 }
 
 func getKustomiztion(path string) (*Images, error) {
@@ -35,13 +60,58 @@ func getKustomiztion(path string) (*Images, error) {
 	return &i, nil
 }
 
-func updateK8sDeployment(namespace string, imagesChan chan *Image) error {
-	builtImages := []*Image{}
-	for image := range imagesChan {
-		builtImages = append(builtImages, image)
+// kustomizationLocks holds a mutex per manifest path, serializing read-modify-writes of
+// the same file.
+var kustomizationLocks sync.Map
+
+func lockKustomization(path string) func() {
+	lock, _ := kustomizationLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// updateK8sDeployments updates the manifest of every namespace concurrently, applying the
+// yq expression when given, and reports the namespaces that failed together.
+func updateK8sDeployments(namespaces []string, builtImages []*Image, yqExpression string, hints func(*Images)) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := []string{}
+	for _, namespace := range lo.Uniq(namespaces) {
+		wg.Add(1)
+		go func(namespace string) {
+			defer wg.Done()
+			err := updateK8sDeployment(namespace, builtImages, hints)
+			if err == nil && yqExpression != "" {
+				err = errors.Wrap(transformKustomization(kustomizationPath(namespace), yqExpression), "transform manifest")
+			}
+			if err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %s", namespace, err))
+				mu.Unlock()
+			}
+		}(namespace)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.Errorf("failed updating manifests: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func updateK8sDeployment(namespace string, builtImages []*Image, hints func(*Images)) error {
+	filePath := kustomizationPath(namespace)
+	defer lockKustomization(filePath)()
+
+	// hints reorder the entries, the manifest is rewritten as a whole for them
+	if hints == nil {
+		patched, err := patchKustomization(filePath, builtImages)
+		if err != nil || patched {
+			return err
+		}
 	}
 
-	filePath := path.Join(".ippon", namespace+".yaml")
 	images, err := getKustomiztion(filePath)
 	if err != nil {
 		return err
@@ -64,12 +134,265 @@ func updateK8sDeployment(namespace string, imagesChan chan *Image) error {
 		}
 	}
 
-	images = &Images{Images: currentImages}
+	updated := &Images{Images: currentImages}
+	if hints != nil {
+		hints(updated)
+	}
+	return writeKustomization(filePath, updated)
+}
 
-	out, err := yaml.Marshal(images)
+// patchKustomization sets the new image of existing manifest entries and appends the
+// missing ones by editing the manifest's text at the positions yaml.v3 reports, so
+// comments, key order, quoting and indentation stay as they are and only the changed
+// lines show up in a diff. It reports false, leaving the manifest alone, when there is
+// no manifest yet or its images list has no block layout to follow.
+func patchKustomization(filePath string, builtImages []*Image) (bool, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var doc yamlv3.Node
+	err = yamlv3.Unmarshal(data, &doc)
+	if err != nil {
+		return false, err
+	}
+	if len(doc.Content) == 0 {
+		return false, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yamlv3.MappingNode {
+		return false, errors.Errorf("manifest %s is not a mapping", filePath)
+	}
+
+	list := mappingValue(root, "images")
+	if list != nil && (list.Kind != yamlv3.SequenceNode || list.Style&yamlv3.FlowStyle != 0 || len(list.Content) == 0) {
+		return false, nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	// inserts holds the lines to add after the given number of lines of the manifest
+	inserts := map[int][]string{}
+	missing := []*Image{}
+	for _, image := range builtImages {
+		var entry *yamlv3.Node
+		if list != nil {
+			entry, _ = lo.Find(list.Content, func(n *yamlv3.Node) bool {
+				oldName := mappingValue(n, "old_image")
+				return oldName != nil && oldName.Value == image.OldName
+			})
+		}
+		if entry == nil {
+			missing = append(missing, image)
+			continue
+		}
+
+		newName := mappingValue(entry, "new_image")
+		if newName == nil {
+			after := lastLine(entry)
+			inserts[after] = append(inserts[after], strings.Repeat(" ", entry.Content[0].Column-1)+"new_image: "+image.NewName)
+			continue
+		}
+
+		line, err := replaceScalar(lines[newName.Line-1], newName.Column-1, newName.Style, image.NewName)
+		if err != nil {
+			return false, errors.Wrapf(err, "manifest %s line %d", filePath, newName.Line)
+		}
+		lines[newName.Line-1] = line
+	}
+
+	if len(missing) > 0 {
+		out, err := yaml.Marshal(missing)
+		if err != nil {
+			return false, err
+		}
+		entries := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+
+		var after int
+		if list == nil {
+			// a new images key goes last, laid out the way writeKustomization does
+			after = len(lines)
+			if lines[after-1] == "" {
+				after--
+			}
+			entries = append([]string{"images:"}, entries...)
+		} else {
+			// new entries follow the dash indentation of the first one
+			first := lines[list.Content[0].Line-1]
+			indent := first[:strings.Index(first, "-")]
+			entries = lo.Map(entries, func(entry string, _ int) string {
+				return indent + entry
+			})
+			after = lastLine(list)
+		}
+		inserts[after] = append(inserts[after], entries...)
+	}
+
+	out := make([]string, 0, len(lines)+len(inserts))
+	for i, line := range lines {
+		out = append(out, line)
+		out = append(out, inserts[i+1]...)
+	}
+	return true, os.WriteFile(filePath, []byte(strings.Join(out, "\n")), 0644)
+}
+
+// lastLine returns the last line of node and its children.
+func lastLine(node *yamlv3.Node) int {
+	last := node.Line
+	for _, child := range node.Content {
+		last = max(last, lastLine(child))
+	}
+	return last
+}
+
+// replaceScalar replaces the single line scalar starting at column of line with value,
+// keeping its quoting and any trailing comment.
+func replaceScalar(line string, column int, style yamlv3.Style, value string) (string, error) {
+	rest := line[column:]
+	var end int
+	switch {
+	case style&yamlv3.DoubleQuotedStyle != 0:
+		end = closingQuote(rest, '"')
+		value = strconv.Quote(value)
+	case style&yamlv3.SingleQuotedStyle != 0:
+		end = closingQuote(rest, '\'')
+		value = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	case style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0:
+		return "", errors.New("new_image can't be a block scalar")
+	default:
+		end = len(rest)
+		if i := strings.Index(rest, " #"); i >= 0 {
+			end = i
+		}
+		end = len(strings.TrimRight(rest[:end], " \t"))
+	}
+	if end < 0 {
+		return "", errors.New("new_image spans several lines")
+	}
+	return line[:column] + value + rest[end:], nil
+}
+
+// closingQuote returns the index right after the quote closing the quoted scalar s
+// starts with, -1 when it isn't closed on the same line.
+func closingQuote(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in a mapping node, nil when missing.
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	if mapping.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scaffoldKustomization adds the images of services missing from the namespace manifest,
+// creating it if needed. Entries already in the manifest are left untouched.
+func scaffoldKustomization(namespace string, images []*Image) error {
+	filePath := kustomizationPath(namespace)
+	defer lockKustomization(filePath)()
+
+	current, err := getKustomiztion(filePath)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = &Images{}
+	}
+
+	for _, image := range images {
+		exists := lo.ContainsBy(current.Images, func(i *Image) bool {
+			return i.OldName == image.OldName
+		})
+		if !exists {
+			current.Images = append(current.Images, image)
+		}
+	}
+
+	return writeKustomization(filePath, current)
+}
+
+// yqLock serializes the bundled yq runs: its command keeps flags and the expression
+// parser in package globals, so two namespaces can't be transformed at the same time.
+var yqLock sync.Mutex
+
+// transformKustomization edits the manifest in place with a yq expression, using the
+// bundled yq so it doesn't have to be installed.
+func transformKustomization(path, expression string) error {
+	defer lockKustomization(path)()
+	yqLock.Lock()
+	defer yqLock.Unlock()
+
+	cmd := yqcmd.New()
+	cmd.SetArgs([]string{"eval", "--inplace", expression, path})
+	cmd.SilenceUsage = true
+	return cmd.Execute()
+}
+
+func writeKustomization(filePath string, images *Images) error {
+	commented := lo.ContainsBy(images.Images, func(i *Image) bool {
+		return i.comment != ""
+	})
+
+	var out []byte
+	var err error
+	if commented {
+		out, err = marshalCommented(images)
+	} else {
+		out, err = yaml.Marshal(images)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(filePath), 0755)
 	if err != nil {
 		return err
 	}
 
 	return os.WriteFile(filePath, out, 0644)
 }
+
+// marshalCommented marshals the manifest with each image's comment above its entry.
+// yaml.v2 can't write comments, they are added to its output so the manifest keeps the
+// layout of uncommented ones.
+func marshalCommented(images *Images) ([]byte, error) {
+	out, err := yaml.Marshal(images)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	entry := 0
+	for _, line := range strings.SplitAfter(string(out), "\n") {
+		// entries are the only lines starting with a dash, yaml.v2 not indenting sequences
+		if strings.HasPrefix(line, "- ") {
+			if entry < len(images.Images) && images.Images[entry].comment != "" {
+				for _, comment := range strings.Split(images.Images[entry].comment, "\n") {
+					b.WriteString("# " + comment + "\n")
+				}
+			}
+			entry++
+		}
+		b.WriteString(line)
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestUpdateK8sDeploymentPreservesManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		images   []*Image
+		want     string
+	}{
+		{
+			name: "unindented sequence",
+			manifest: `# managed by ippon
+images:
+# api service
+- old_image: registry.lema.ai/api
+  new_image: x.io/api@sha256:00 # pinned
+- new_image: "x.io/web@sha256:00"
+  old_image: registry.lema.ai/web
+`,
+			images: []*Image{
+				{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"},
+				{OldName: "registry.lema.ai/web", NewName: "x.io/web@sha256:11"},
+				{OldName: "registry.lema.ai/db", NewName: "x.io/db@sha256:22"},
+			},
+			want: `# managed by ippon
+images:
+# api service
+- old_image: registry.lema.ai/api
+  new_image: x.io/api@sha256:11 # pinned
+- new_image: "x.io/web@sha256:11"
+  old_image: registry.lema.ai/web
+- old_image: registry.lema.ai/db
+  new_image: x.io/db@sha256:22
+`,
+		},
+		{
+			name: "indented sequence followed by other keys",
+			manifest: `images:
+  - old_image: registry.lema.ai/api
+    new_image: 'x.io/api@sha256:00'
+# trailing keys stay put
+namespace: dev
+`,
+			images: []*Image{
+				{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"},
+				{OldName: "registry.lema.ai/db", NewName: "x.io/db@sha256:22"},
+			},
+			want: `images:
+  - old_image: registry.lema.ai/api
+    new_image: 'x.io/api@sha256:11'
+  - old_image: registry.lema.ai/db
+    new_image: x.io/db@sha256:22
+# trailing keys stay put
+namespace: dev
+`,
+		},
+		{
+			name: "no images key",
+			manifest: `# placeholder
+namespace: dev
+`,
+			images: []*Image{
+				{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"},
+			},
+			want: `# placeholder
+namespace: dev
+images:
+- old_image: registry.lema.ai/api
+  new_image: x.io/api@sha256:11
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			viper.Set("images_output_dir", dir)
+			t.Cleanup(func() { viper.Set("images_output_dir", defaultImagesOutputDir) })
+
+			filePath := filepath.Join(dir, "dev.yaml")
+			if err := os.WriteFile(filePath, []byte(test.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := updateK8sDeployment("dev", test.images, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("manifest:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarshalCommentedKeepsLayout(t *testing.T) {
+	images := &Images{Images: []*Image{
+		{OldName: "registry.lema.ai/db", NewName: "x.io/db@sha256:22", comment: "wave 0"},
+		{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11", comment: "wave 1"},
+	}}
+
+	got, err := marshalCommented(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `images:
+# wave 0
+- old_image: registry.lema.ai/db
+  new_image: x.io/db@sha256:22
+# wave 1
+- old_image: registry.lema.ai/api
+  new_image: x.io/api@sha256:11
+`
+	if string(got) != want {
+		t.Errorf("manifest:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUpdateK8sDeploymentsConcurrentNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("images_output_dir", dir)
+	t.Cleanup(func() { viper.Set("images_output_dir", defaultImagesOutputDir) })
+
+	namespaces := []string{"dev", "staging", "prod", "qa", "demo", "perf"}
+	images := []*Image{{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"}}
+
+	err := updateK8sDeployments(namespaces, images, `.namespace = "ippon"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// yq writes its own indentation
+	want := `images:
+  - old_image: registry.lema.ai/api
+    new_image: x.io/api@sha256:11
+namespace: ippon
+`
+	for _, namespace := range namespaces {
+		got, err := os.ReadFile(filepath.Join(dir, namespace+".yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s manifest:\n%s\nwant:\n%s", namespace, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/lema-ai/ippon/backend"
+	"github.com/lema-ai/ippon/registry"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	defaultKeychainName = "default"
+	ecrKeychainName     = "ecr"
+	envKeychainName     = "env"
+)
+
+// envKeychain resolves credentials from IPPON_REGISTRY_USERNAME and IPPON_REGISTRY_PASSWORD.
+// When IPPON_REGISTRY_HOST is set the credentials are only used for that registry.
+type envKeychain struct{}
+
+func (this envKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	username := os.Getenv("IPPON_REGISTRY_USERNAME")
+	password := os.Getenv("IPPON_REGISTRY_PASSWORD")
+	if username == "" || password == "" {
+		return authn.Anonymous, nil
+	}
+
+	if host := os.Getenv("IPPON_REGISTRY_HOST"); host != "" && host != target.RegistryStr() {
+		return authn.Anonymous, nil
+	}
+
+	return &authn.Basic{Username: username, Password: password}, nil
+}
+
+// registryKeychain answers the hosts of a self authenticating registry with its
+// credentials, leaving every other registry to the following keychains.
+type registryKeychain struct {
+	hosts []string
+	auth  authn.Authenticator
+}
+
+func (this registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if lo.Contains(this.hosts, target.RegistryStr()) {
+		return this.auth, nil
+	}
+	return authn.Anonymous, nil
+}
+
+// withRegistryAuth puts the credentials of a self authenticating registry ahead of keychain
+// for the registry's hosts, so every registry call, not only publishing, uses them.
+func withRegistryAuth(keychain authn.Keychain, reg SelfAuthRegistry) (authn.Keychain, error) {
+	urls := []string{reg.URL()}
+	if multi, ok := reg.(MultiURLRegistry); ok {
+		urls = multi.URLs()
+	}
+
+	hosts := []string{}
+	for _, url := range urls {
+		repo, err := name.NewRepository(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse registry URL %s", url)
+		}
+		hosts = append(hosts, repo.RegistryStr())
+	}
+
+	return authn.NewMultiKeychain(registryKeychain{hosts: lo.Uniq(hosts), auth: reg.Authenticator()}, keychain), nil
+}
+
+// buildKeychain composes the named keychains in order, the first one returning
+// non-anonymous credentials for a registry wins. Names other than the built-in ones
+// refer to keychains registered with backend.RegisterKeychain.
+func buildKeychain(names []string, ecr *registry.ECR) (authn.Keychain, error) {
+	if len(names) == 0 {
+		return authn.DefaultKeychain, nil
+	}
+
+	keychains := make([]authn.Keychain, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case defaultKeychainName:
+			keychains = append(keychains, authn.DefaultKeychain)
+		case ecrKeychainName:
+			// without an ECR registry there are no AWS credentials to resolve from
+			if ecr != nil {
+				keychains = append(keychains, authn.NewKeychainFromHelper(ecr.CredentialHelper()))
+			}
+		case envKeychainName:
+			keychains = append(keychains, envKeychain{})
+		default:
+			keychain, err := backend.GetKeychain(name)
+			if err != nil {
+				return nil, err
+			}
+			keychains = append(keychains, keychain)
+		}
+	}
+
+	return authn.NewMultiKeychain(keychains...), nil
+}
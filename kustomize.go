@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// kustomizeImagesExpression is the yq expression pointing each image's entry in the images
+// list of a kustomization.yaml at its pushed digest, adding the missing entries. Entries
+// keep their position and comments.
+func kustomizeImagesExpression(images []*Image) (string, error) {
+	updates := []string{".images = (.images // [])"}
+	for _, image := range images {
+		newName, digest, ok := strings.Cut(image.NewName, "@")
+		if !ok {
+			return "", errors.Errorf("image %s has no digest", image.NewName)
+		}
+		name, newName, digest := strconv.Quote(image.OldName), strconv.Quote(newName), strconv.Quote(digest)
+
+		// the bundled yq has no if, entries are added through a list empty when they exist
+		updates = append(updates, fmt.Sprintf(
+			`(.images | any_c(.name == %s)) as $exists | (.images[] | select(.name == %s)) |= (.newName = %s | .digest = %s | del(.newTag)) | .images += ([{"name": %s, "newName": %s, "digest": %s}] | map(select($exists | not)))`,
+			name, name, newName, digest, name, newName, digest,
+		))
+	}
+	return strings.Join(updates, " | "), nil
+}
+
+// updateKustomizeImages writes the images into the images list of a kustomization.yaml,
+// which kustomize build applies directly.
+func updateKustomizeImages(path string, images []*Image) error {
+	if _, err := os.Stat(path); err != nil {
+		return errors.Wrap(err, "read kustomization")
+	}
+
+	expression, err := kustomizeImagesExpression(lo.Filter(images, func(i *Image, _ int) bool {
+		return i != nil
+	}))
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(transformKustomization(path, expression), "update kustomization images")
+}
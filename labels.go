@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// freshnessLabels computes the freshness_labels config, a map of label to window such as
+// com.ourorg.rebuild-after: 720h. Each label is set to buildTime plus its window, in UTC
+// and RFC 3339 (2024-05-01T12:00:00Z), for tooling to spot images due for a rebuild.
+// Windows use Go duration syntax, hours being the largest unit.
+func freshnessLabels(buildTime time.Time) (map[string]string, error) {
+	windows := viper.GetStringMapString("freshness_labels")
+	labels := make(map[string]string, len(windows))
+	for label, value := range windows {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, &ConfigError{Err: errors.Wrapf(err, "invalid freshness_labels window for %s", label)}
+		}
+		labels[label] = buildTime.Add(window).UTC().Format(time.RFC3339)
+	}
+	return labels, nil
+}
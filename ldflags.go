@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// gitInfo is the .Git of ldflags templates. Tag is empty unless HEAD is tagged.
+type gitInfo struct {
+	Commit      string
+	ShortCommit string
+	Tag         string
+	Branch      string
+}
+
+// readGitInfo resolves the git state ldflags templates refer to, failing outside of a
+// git repository.
+func readGitInfo(ctx context.Context) (*gitInfo, error) {
+	commit, err := runGit(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve git commit for ldflags")
+	}
+
+	info := &gitInfo{Commit: commit, ShortCommit: commit[:min(len(commit), 7)]}
+	if tag, err := runGit(ctx, "describe", "--tags", "--exact-match", "HEAD"); err == nil {
+		info.Tag = tag
+	}
+	if branch, err := currentGitBranch(ctx); err == nil {
+		info.Branch = branch
+	}
+	return info, nil
+}
+
+// expandLdflags executes the ldflags as templates over .Git and .Env, the environment,
+// the way ko templates its own ldflags: -X main.version={{.Git.Tag}}.
+func expandLdflags(ldflags []string, git *gitInfo) ([]string, error) {
+	data := map[string]interface{}{
+		"Git": git,
+		"Env": lo.SliceToMap(os.Environ(), func(entry string) (string, string) {
+			key, value, _ := strings.Cut(entry, "=")
+			return key, value
+		}),
+	}
+
+	expanded := make([]string, 0, len(ldflags))
+	for _, ldflag := range ldflags {
+		tmpl, err := template.New("ldflags").Option("missingkey=error").Parse(ldflag)
+		if err != nil {
+			return nil, &ConfigError{Err: errors.Wrapf(err, "invalid ldflags %q", ldflag)}
+		}
+
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, errors.Wrapf(err, "expand ldflags %q", ldflag)
+		}
+		expanded = append(expanded, out.String())
+	}
+	return expanded, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/ko/pkg/publish"
+)
+
+// localRegistry stands for the local Docker daemon, images are loaded into it under the
+// ko.local domain rather than pushed.
+type localRegistry struct{}
+
+func (this localRegistry) Init(context.Context) error {
+	return nil
+}
+
+func (this localRegistry) URL() string {
+	return publish.LocalDomain
+}
@@ -7,37 +7,54 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 
-	"github.com/google/ko/pkg/publish"
+	"github.com/lema-ai/ippon/backend"
+	"github.com/lema-ai/ippon/registry"
 	yqcmd "github.com/mikefarah/yq/v4/cmd"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-type Registry interface {
-	Init(context.Context) error
-	URL() string
-}
+// the registry interfaces live with the registries implementing them
+type (
+	Registry           = registry.Registry
+	CreateRepoRegistry = registry.CreateRepoRegistry
+	SelfAuthRegistry   = registry.SelfAuthRegistry
+	EnvRegistry        = registry.EnvRegistry
+	MultiURLRegistry   = registry.MultiURLRegistry
+)
 
-type CreateRepoRegistry interface {
-	Registry
-	RepositoryExists(ctx context.Context, repo string) (bool, error)
-	CreateRepository(ctx context.Context, repo string) error
-}
+const (
+	defaultBaseImage  = "cgr.dev/chainguard/busybox:latest"
+	defaultBranch     = "main"
+	defaultPlatform   = "linux/amd64"
+	defaultDebugShell = "/bin/sh"
+	latestTag         = "latest"
+	configFileName    = "ippon"
+	configEnvPrefix   = "IPPON"
 
-type SelfAuthRegistry interface {
-	Registry
-	GetAuthOption() publish.Option
-}
+	// configFlagUsage documents --config, shared by every command reading the config
+	configFlagUsage = "Path to ippon config file, a glob or a directory of them. Repeatable: settings come from the first file, go_services are merged from all"
 
-const (
-	defaultBaseImage = "cgr.dev/chainguard/busybox:latest"
-	configFileName   = "ippon"
-	configEnvPrefix  = "IPPON"
+	oktetoRegistryName    = "okteto"
+	gcrRegistryName       = "gcr"
+	ghcrRegistryName      = "ghcr"
+	dockerHubRegistryName = "dockerhub"
+	ecrRegistryName       = "ecr"
+	// prodRegistryName is the former name of the ecr command, kept as its alias and as the
+	// config section read when there is no ecr section
+	prodRegistryName = "prod"
 )
 
 var (
+	// registryNames are the registry commands, each reading its own config section
+	registryNames = []string{oktetoRegistryName, ecrRegistryName, gcrRegistryName, ghcrRegistryName, dockerHubRegistryName}
+	// registrySections are the config sections of the registry commands
+	registrySections = []string{oktetoRegistryName, ecrRegistryName, prodRegistryName, gcrRegistryName, ghcrRegistryName, dockerHubRegistryName}
+
 	outputBuffer bytes.Buffer // easier debugging in case of errors, buffer to store output when running in non verbose mode
 )
 
@@ -50,11 +67,12 @@ func tryCallParentPersistentPreRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func buildRegistryCommand(cmdName string) (*cobra.Command, error) {
+func buildRegistryCommand(cmdName string, aliases ...string) (*cobra.Command, error) {
 	ctx := context.Background()
 	registryCmd := &cobra.Command{
-		Use:  cmdName,
-		Args: cobra.MinimumNArgs(1),
+		Use:     cmdName,
+		Aliases: aliases,
+		Args:    cobra.MinimumNArgs(1),
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			return tryCallParentPersistentPreRun(cmd, args)
 		},
@@ -67,9 +85,74 @@ func buildRegistryCommand(cmdName string) (*cobra.Command, error) {
 			return registryCommand(ctx, cmd, args, cmdName)
 		},
 	}
-	releaseCmd.Flags().Int("max-go-routines", 5, "Maximum number of go routines to use for building and pushing images concurrently. Default is 5.")
+	releaseCmd.Flags().Duration("timeout", 0, "Cancel the release when it takes longer than this, as when interrupted by SIGINT or SIGTERM")
+	releaseCmd.Flags().Int("max-go-routines", 0, "Maximum number of go routines to use for building and pushing images concurrently. Defaults to the number of CPUs.")
+	releaseCmd.Flags().StringSlice("platform", nil, "Platforms to build every service for, overriding platforms in config")
+	releaseCmd.Flags().Int("platform-concurrency", 0, "Maximum number of platforms of a service built concurrently, 0 for GOMAXPROCS")
+	releaseCmd.Flags().Int("build-retries", 2, "Number of times a build failing on transient module download errors is retried")
+	releaseCmd.Flags().Int("publish-retries", 3, "Number of times a push failing on registry throttling, server or network errors is retried")
+	releaseCmd.Flags().Duration("publish-retry-backoff", time.Second, "Wait before the first push retry, doubled on every following one")
+	releaseCmd.Flags().Bool("plan", false, "Print the build waves and their concurrency without building anything, as JSON with --output json")
+	releaseCmd.Flags().String("log-dir", "", "Also write each service's release log to <dir>/<service>.log, "+defaultLogDir+" when given without a value")
+	releaseCmd.Flags().Lookup("log-dir").NoOptDefVal = defaultLogDir
+	releaseCmd.Flags().String("warmup-service", "", "Service built alone before the others to warm the Go build cache, \""+warmupFirstService+"\" for the first service (also cache_warmup_service in config)")
+	releaseCmd.Flags().Bool("fail-fast", true, "Stop the release at the first failed service, the warmup service included, cancelling the builds and pushes in flight")
+	releaseCmd.Flags().Bool("keep-going", false, "Release every service despite failures and list all of them at the end, same as --fail-fast=false")
+	releaseCmd.Flags().Bool("serial", false, "Build one service at a time in config order, dependencies first, without a warmup service, same as --max-go-routines 1")
+	releaseCmd.Flags().Int64("base-pull-concurrency", 2, "Maximum number of base images pulled concurrently, independent of max-go-routines")
+	releaseCmd.Flags().StringArray("registry-header", nil, "Extra Key=Value header sent with every registry request, in addition to registry auth")
 	releaseCmd.Flags().String("namespace", "", "Okteto namespace to update the kustomization file with the new image digests")
-	releaseCmd.Flags().String("config", "ippon.yaml", "Path to ippon config file")
+	releaseCmd.Flags().StringArray("config", []string{"ippon.yaml"}, configFlagUsage)
+	releaseCmd.Flags().Bool("tag-latest-only-on-default-branch", false, "Only push the latest tag when releasing from the default branch (default_branch in config)")
+	releaseCmd.Flags().String("branch", "", "Branch being released, detected from git when empty")
+	releaseCmd.Flags().StringSlice("exclude", nil, "Services to skip, in addition to excluded_services in the config and the excluded services file (also --exclude-services)")
+	releaseCmd.Flags().SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "exclude-services" {
+			name = "exclude"
+		}
+		return pflag.NormalizedName(name)
+	})
+	releaseCmd.Flags().String("excluded-services-file", "", "Path to a YAML file with an excluded_services list")
+	releaseCmd.Flags().StringSlice("set", nil, "Only release the services of these release sets, minus the excluded ones")
+	releaseCmd.Flags().String("release-sets-file", "release-sets.yaml", "Path to a YAML file mapping release set names to service lists")
+	releaseCmd.Flags().StringSlice("services", nil, "Only release these services, minus the excluded ones")
+	releaseCmd.Flags().String("match", "", "Only release services whose name matches this glob, or regular expression when wrapped in slashes")
+	releaseCmd.Flags().Bool("immutable-tags", false, "Fail before building if a tag to push already exists in ECR (also <registry>.immutable_tags in config)")
+	releaseCmd.Flags().Bool("channels-only-on-default-branch", false, "Only push the services' channel tags when releasing from the default branch")
+	releaseCmd.Flags().Bool("fail-on-stale-base", false, "Fail instead of warning when a base image is older than max_base_age")
+	releaseCmd.Flags().Bool("ephemeral-registry", false, "Push to an in-memory registry served for the duration of the release and print the references")
+	releaseCmd.Flags().Bool("local", false, "Load the images into the local Docker daemon as ko.local/<repo> instead of pushing them to the registry")
+	releaseCmd.Flags().Bool("require-clean-tree", false, "Refuse to release from a git tree with uncommitted changes")
+	releaseCmd.Flags().Bool("allow-dirty", false, "With --require-clean-tree, release a dirty tree anyway, suffixing git derived tags with -dirty")
+	releaseCmd.Flags().Bool("git-tags", false, "Tag images with the short commit SHA, the branch and HEAD's semver tag, when the config sets no tags")
+	releaseCmd.Flags().Bool("branch-tag", false, "Also tag images with the git branch (or --branch), sanitized into a valid tag")
+	releaseCmd.Flags().Bool("no-git-annotations", false, "Don't annotate images with the revision, source, creation time and version of the git checkout")
+	releaseCmd.Flags().Bool("require-tags", false, "Fail the release if any service resolves to no tags")
+	releaseCmd.Flags().StringArray("attach", nil, "Attach a file to every published image as an OCI artifact, as type=path. SERVICE_NAME in the path is replaced by the service name")
+	releaseCmd.Flags().String("report-file", "", "Write per-service results to this path as JUnit XML, or JSON when it ends in .json")
+	releaseCmd.Flags().String("output", "", "Print a JSON summary of the released services and the bill of materials to stdout, with json")
+	releaseCmd.Flags().Bool("print-urls", false, "Print the pushed reference (registry/repo@sha256:...) of every service")
+	releaseCmd.Flags().String("urls-file", "", "Write the pushed references to this file, as JSON when it ends in .json")
+	releaseCmd.Flags().Bool("resume", false, "Skip services published by an interrupted release, as recorded in .ippon/checkpoint.json")
+	releaseCmd.Flags().Bool("only-changed-config", false, "Only rebuild services whose source or effective config changed since the last release, as recorded in .ippon/build-cache.json")
+	releaseCmd.Flags().Bool("debug-entrypoint", false, "Build images with a shell entrypoint (debug_shell in config) for debugging, tags get a -debug suffix")
+	releaseCmd.Flags().Bool("two-phase", false, "Push every service with a staging tag first and apply the real tags only once all of them succeeded")
+	releaseCmd.Flags().Bool("shuffle-order", false, "Build services in a random order, to measure the effect of build ordering on caching")
+	releaseCmd.Flags().Int64("shuffle-seed", 0, "Seed for --shuffle-order, random when 0")
+	releaseCmd.Flags().Bool("sbom", false, "Generate an SPDX SBOM of every service and push it next to the image (also sbom in config)")
+	releaseCmd.Flags().String("sbom-dir", "", "Write an SPDX SBOM of every service to DIR/<service>.spdx.json, without attaching it to the image")
+	releaseCmd.Flags().String("manifest-yq", "", "yq expression applied in place to the manifest after it is updated")
+	releaseCmd.Flags().String("manifest-order-hints", "", "Order manifest entries by depends_on and mark their rollout wave, as a sync-wave annotation or a comment")
+	releaseCmd.Flags().String("deployment-format", manifestDeploymentFormat, "Where the pushed digests are written: manifest, the namespace manifests or --kustomization, or helm, the helm_values file in config")
+	releaseCmd.Flags().String("kustomization", "", "kustomization.yaml whose images list is pointed at the pushed digests, instead of the namespace manifests")
+	releaseCmd.Flags().Bool("legacy-images-format", false, "With --kustomization, also update the old_image/new_image namespace manifests")
+	releaseCmd.Flags().String("images-output-dir", "", "Directory of the namespace manifests, <dir>/<namespace>.yaml (also images_output_dir in config, defaults to "+defaultImagesOutputDir+")")
+	releaseCmd.Flags().Bool("no-manifest", false, "Only build and push, never update the namespace manifest")
+	releaseCmd.Flags().String("images-output", "", "Write the pushed images as a JSON object of service to reference to this path, - for stdout")
+	releaseCmd.Flags().Bool("verify-manifest", false, "Check every image in the written manifest can be resolved from its registry")
+	releaseCmd.Flags().StringSlice("manifest-namespace", nil, "Additional namespaces whose manifest is updated with the released images, concurrently")
+	releaseCmd.Flags().Bool("progress", false, "Show the status of every service on stderr, redrawn live on a terminal and as timestamped lines otherwise")
+	releaseCmd.Flags().Bool("profile-builds", false, "Collect the CPU time of each service build and the release-wide peak memory, and print them as JSON")
 	registryCmd.AddCommand(releaseCmd)
 
 	createMissingCmd := &cobra.Command{
@@ -80,16 +163,34 @@ func buildRegistryCommand(cmdName string) (*cobra.Command, error) {
 		},
 	}
 	createMissingCmd.Flags().String("namespace", "", "Okteto namespace to use for the missing repositories")
-	createMissingCmd.Flags().String("config", "ippon.yaml", "Path to ippon config file")
+	createMissingCmd.Flags().StringArray("config", []string{"ippon.yaml"}, configFlagUsage)
+	createMissingCmd.Flags().Bool("immutable-tags", false, "Create repositories with immutable tags (also <registry>.immutable_tags in config)")
+	createMissingCmd.Flags().Bool("reconcile-policy", false, "Also apply <registry>.repository_policy to the repositories that already exist")
+	createMissingCmd.Flags().Int("max-go-routines", 10, "Maximum number of repositories checked and created concurrently")
+	createMissingCmd.Flags().Bool("dry-run", false, "Only report the missing repositories, without creating them")
+	createMissingCmd.Flags().String("output", "", "Write the created, existing, missing and failed repositories as JSON to this path")
+	createMissingCmd.Flags().Bool("scaffold", false, "Also add the services missing from the namespace manifest, with placeholder images")
 	registryCmd.AddCommand(createMissingCmd)
 
+	authCheckCmd := &cobra.Command{
+		Use:   "auth-check",
+		Short: "Check the registry credentials allow pushing every service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authCheckCommand(ctx, cmd, args, cmdName)
+		},
+	}
+	authCheckCmd.Flags().String("namespace", "", "Okteto namespace the repositories are in")
+	authCheckCmd.Flags().StringArray("config", []string{"ippon.yaml"}, configFlagUsage)
+	registryCmd.AddCommand(authCheckCmd)
+
 	return registryCmd, nil
 }
 
 func finishWithError(msg string, err error) {
 	fmt.Print(outputBuffer.String())
 	log.SetOutput(os.Stdout)
-	log.Fatalf("%s: %v\n", msg, err)
+	log.Printf("%s: %v\n", msg, err)
+	os.Exit(exitCode(err))
 }
 
 func init() {
@@ -97,21 +198,46 @@ func init() {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 	viper.SetDefault("base_image", defaultBaseImage)
+	viper.SetDefault("default_branch", defaultBranch)
+	viper.SetDefault("debug_shell", defaultDebugShell)
+	viper.SetDefault("old_image_prefix", defaultOldImagePrefix)
+	viper.SetDefault("images_output_dir", defaultImagesOutputDir)
+	viper.SetDefault("platforms", []string{defaultPlatform})
+	viper.SetDefault("warmup_strict", true)
+	viper.SetDefault("builder", backend.DefaultBuilder)
+	viper.SetDefault("publisher", backend.DefaultPublisher)
+	// the ecr keychain only answers for ECR hosts, everything else falls through to the docker config
+	viper.SetDefault("keychains", []string{ecrKeychainName, defaultKeychainName})
 	viper.SetEnvPrefix(configEnvPrefix)
 	viper.AutomaticEnv()
 }
 
 func main() {
-	oktetoCommand, err := buildRegistryCommand("okteto")
+	oktetoCommand, err := buildRegistryCommand(oktetoRegistryName)
 	if err != nil {
 		finishWithError("failed creating okteto command", err)
 	}
 
-	releaseCommand, err := buildRegistryCommand("prod")
+	releaseCommand, err := buildRegistryCommand(ecrRegistryName, prodRegistryName)
 	if err != nil {
 		finishWithError("failed creating release command", err)
 	}
 
+	gcrCommand, err := buildRegistryCommand(gcrRegistryName)
+	if err != nil {
+		finishWithError("failed creating gcr command", err)
+	}
+
+	ghcrCommand, err := buildRegistryCommand(ghcrRegistryName)
+	if err != nil {
+		finishWithError("failed creating ghcr command", err)
+	}
+
+	dockerHubCommand, err := buildRegistryCommand(dockerHubRegistryName)
+	if err != nil {
+		finishWithError("failed creating dockerhub command", err)
+	}
+
 	// so we don't require everyone to install yq directly
 	// thankfully it's written in Go and with cobra!
 	yqCmd := yqcmd.New()
@@ -134,8 +260,41 @@ func main() {
 		},
 	}
 
+	manifestCheckCmd := &cobra.Command{
+		Use:   "manifest-check",
+		Short: "Compare the namespace manifest against the configured services",
+		RunE:  manifestCheckCommand,
+	}
+	manifestCheckCmd.Flags().String("namespace", "", "Namespace whose manifest to check")
+	manifestCheckCmd.Flags().StringArray("config", []string{"ippon.yaml"}, configFlagUsage)
+	manifestCheckCmd.Flags().Bool("prune", false, "Remove manifest entries of services missing from the config")
+	manifestCheckCmd.MarkFlagRequired("namespace")
+
+	manifestDiffCmd := &cobra.Command{
+		Use:   "manifest-diff A.yaml B.yaml",
+		Short: "Show the services whose image changed, was added or was removed between two manifests",
+		Args:  cobra.ExactArgs(2),
+		RunE:  manifestDiffCommand,
+	}
+	manifestDiffCmd.Flags().String("output", "text", "Output format, text or json")
+
+	buildCmd := &cobra.Command{
+		Use:   "build SERVICE",
+		Short: "Build a service's image without pushing it and print its digest",
+		Args:  cobra.ExactArgs(1),
+		RunE:  buildCommand,
+	}
+	buildCmd.Flags().StringArray("config", []string{"ippon.yaml"}, configFlagUsage)
+	buildCmd.Flags().StringSlice("platform", nil, "Platforms to build for, overriding the service's platforms in config")
+	buildCmd.Flags().String("output", "", "With tar, also write the image as an OCI image layout tarball")
+	buildCmd.Flags().String("tar-file", "", "Path of the --output tar tarball, defaults to <service>.tar")
+
 	rootCmd.PersistentFlags().Bool("verbose", false, "verbose output")
-	rootCmd.AddCommand(oktetoCommand, releaseCommand, yqCmd)
+	rootCmd.PersistentFlags().Bool("lax-config", false, "Ignore unknown config keys instead of failing")
+	viper.BindPFlag("lax_config", rootCmd.PersistentFlags().Lookup("lax-config"))
+	rootCmd.PersistentFlags().String("env", "", "Environment selecting the base_images default, also IPPON_ENV")
+	viper.BindPFlag("env", rootCmd.PersistentFlags().Lookup("env"))
+	rootCmd.AddCommand(oktetoCommand, releaseCommand, gcrCommand, ghcrCommand, dockerHubCommand, yqCmd, manifestCheckCmd, manifestDiffCmd, buildCmd)
 	err = rootCmd.Execute()
 	if err != nil {
 		finishWithError("failed executing command", err)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestECRCommandNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		section string
+	}{
+		{
+			name:    "ecr section",
+			config:  "ecr:\n  account: \"123456789012\"\n",
+			section: ecrRegistryName,
+		},
+		{
+			name:    "prod section of older configs",
+			config:  "prod:\n  account: \"123456789012\"\n",
+			section: prodRegistryName,
+		},
+	}
+
+	for _, test := range tests {
+		for _, cmdName := range []string{ecrRegistryName, prodRegistryName} {
+			t.Run(test.name+" "+cmdName, func(t *testing.T) {
+				configPath := filepath.Join(t.TempDir(), "ippon.yaml")
+				if err := os.WriteFile(configPath, []byte(test.config+"go_services: []\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				registryCmd, err := buildRegistryCommand(ecrRegistryName, prodRegistryName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rootCmd := &cobra.Command{Use: "ippon", SilenceUsage: true, SilenceErrors: true}
+				rootCmd.AddCommand(registryCmd)
+				rootCmd.SetOut(io.Discard)
+				rootCmd.SetArgs([]string{cmdName, "release", "--config", configPath})
+
+				// the missing region stops the release once the handler read the section
+				err = rootCmd.Execute()
+				want := test.section + ".region is not set"
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v, want %q", err, want)
+				}
+			})
+		}
+	}
+}
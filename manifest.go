@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+// manifestCheckCommand reports manifest entries without a configured service (orphans)
+// and configured services that were never written to the manifest (missing).
+func manifestCheckCommand(cmd *cobra.Command, _ []string) error {
+	configPaths, err := cmd.Flags().GetStringArray("config")
+	if err != nil {
+		return errors.Wrap(err, "failed getting config flag")
+	}
+
+	namespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return errors.Wrap(err, "failed getting namespace flag")
+	}
+
+	prune, err := cmd.Flags().GetBool("prune")
+	if err != nil {
+		return errors.Wrap(err, "failed getting prune flag")
+	}
+
+	services, err := getServicesConfig(configPaths)
+	if err != nil {
+		return errors.Wrap(err, "get services config")
+	}
+
+	filePath := kustomizationPath(namespace)
+	images, err := getKustomiztion(filePath)
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+	if images == nil {
+		images = &Images{}
+	}
+
+	configured := lo.SliceToMap(services.GoServices, func(s GoServiceConfig) (string, string) {
+		return s.OldImageName(), s.Name
+	})
+	inManifest := lo.SliceToMap(images.Images, func(i *Image) (string, bool) {
+		return i.OldName, true
+	})
+
+	orphans := lo.Filter(images.Images, func(i *Image, _ int) bool {
+		_, ok := configured[i.OldName]
+		return !ok
+	})
+	missing := lo.FilterMap(services.GoServices, func(s GoServiceConfig, _ int) (string, bool) {
+		return s.Name, !inManifest[s.OldImageName()]
+	})
+
+	for _, orphan := range orphans {
+		fmt.Printf("orphan: %s (%s)\n", orphan.OldName, orphan.NewName)
+	}
+	for _, name := range missing {
+		fmt.Printf("missing: %s\n", name)
+	}
+
+	if prune && len(orphans) > 0 {
+		images.Images = lo.Without(images.Images, orphans...)
+		if err := writeKustomization(filePath, images); err != nil {
+			return errors.Wrap(err, "write pruned manifest")
+		}
+		fmt.Printf("pruned %d orphans from %s\n", len(orphans), filePath)
+		return nil
+	}
+
+	if len(orphans) > 0 || len(missing) > 0 {
+		return errors.Errorf("manifest %s is out of sync with the config: %d orphans, %d missing", filePath, len(orphans), len(missing))
+	}
+	return nil
+}
+
+type imageChange struct {
+	OldName string `json:"old_image"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+type manifestDiff struct {
+	Changed []imageChange `json:"changed"`
+	Added   []*Image      `json:"added"`
+	Removed []*Image      `json:"removed"`
+}
+
+// diffManifests compares two manifests by old image name, entries are sorted by name.
+func diffManifests(a, b *Images) *manifestDiff {
+	before := lo.SliceToMap(a.Images, func(i *Image) (string, *Image) {
+		return i.OldName, i
+	})
+	after := lo.SliceToMap(b.Images, func(i *Image) (string, *Image) {
+		return i.OldName, i
+	})
+
+	diff := &manifestDiff{Changed: []imageChange{}, Added: []*Image{}, Removed: []*Image{}}
+	for oldName, image := range after {
+		previous, ok := before[oldName]
+		if !ok {
+			diff.Added = append(diff.Added, image)
+		} else if previous.NewName != image.NewName {
+			diff.Changed = append(diff.Changed, imageChange{OldName: oldName, From: previous.NewName, To: image.NewName})
+		}
+	}
+	for oldName, image := range before {
+		if _, ok := after[oldName]; !ok {
+			diff.Removed = append(diff.Removed, image)
+		}
+	}
+
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].OldName < diff.Changed[j].OldName })
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].OldName < diff.Added[j].OldName })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].OldName < diff.Removed[j].OldName })
+	return diff
+}
+
+// manifestDiffCommand prints which services changed image, were added or were removed
+// between two manifests.
+func manifestDiffCommand(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return errors.Wrap(err, "failed getting output flag")
+	}
+	if output != "text" && output != "json" {
+		return errors.Errorf("unknown output %q, expected text or json", output)
+	}
+
+	manifests := make([]*Images, 0, len(args))
+	for _, path := range args {
+		images, err := getKustomiztion(path)
+		if err != nil {
+			return errors.Wrapf(err, "read manifest %s", path)
+		}
+		if images == nil {
+			return errors.Errorf("manifest %s not found", path)
+		}
+		manifests = append(manifests, images)
+	}
+
+	diff := diffManifests(manifests[0], manifests[1])
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	for _, change := range diff.Changed {
+		fmt.Printf("changed: %s (%s -> %s)\n", change.OldName, change.From, change.To)
+	}
+	for _, image := range diff.Added {
+		fmt.Printf("added: %s (%s)\n", image.OldName, image.NewName)
+	}
+	for _, image := range diff.Removed {
+		fmt.Printf("removed: %s (%s)\n", image.OldName, image.NewName)
+	}
+	return nil
+}
+
+// verifyManifestImages checks every image recorded in the manifest can be resolved from
+// its registry, catching pushes that reported success but left nothing pullable.
+func verifyManifestImages(ctx context.Context, path string, options ...remote.Option) error {
+	images, err := getKustomiztion(path)
+	if err != nil {
+		return errors.Wrapf(err, "read manifest %s", path)
+	}
+	if images == nil {
+		return errors.Errorf("manifest %s not found", path)
+	}
+
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	unresolved := []string{}
+	for _, image := range images.Images {
+		ref, err := name.ParseReference(image.NewName)
+		if err == nil {
+			_, err = remote.Head(ref, options...)
+		}
+		if err != nil {
+			log.Printf("ippon failed resolving %s: %v\n", image.NewName, err)
+			unresolved = append(unresolved, image.NewName)
+		}
+	}
+
+	if len(unresolved) > 0 {
+		return errors.Errorf("%d images in %s can't be resolved: %s", len(unresolved), path, strings.Join(unresolved, ", "))
+	}
+	log.Printf("ippon verified %d images in %s\n", len(images.Images), path)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type planWave struct {
+	Services []string `json:"services"`
+	// Concurrency is how many of the wave's services build at the same time
+	Concurrency int `json:"concurrency"`
+}
+
+// releasePlan is the order services of a release build in: the warmup service alone,
+// then one wave per depends_on level, each starting once the previous one is released, its
+// services building as slots free up in config (or shuffled) order.
+type releasePlan struct {
+	MaxGoRoutines int        `json:"max_go_routines"`
+	Waves         []planWave `json:"waves"`
+}
+
+func newReleasePlan(warmup *GoServiceConfig, waves [][]GoServiceConfig, maxGoRoutines int) *releasePlan {
+	plan := &releasePlan{MaxGoRoutines: maxGoRoutines, Waves: []planWave{}}
+	if warmup != nil {
+		plan.Waves = append(plan.Waves, planWave{Services: []string{warmup.Name}, Concurrency: 1})
+	}
+	for _, services := range waves {
+		if len(services) == 0 {
+			continue
+		}
+		plan.Waves = append(plan.Waves, planWave{
+			Services: lo.Map(services, func(s GoServiceConfig, _ int) string {
+				return s.Name
+			}),
+			Concurrency: min(maxGoRoutines, len(services)),
+		})
+	}
+	return plan
+}
+
+// Write prints the plan as text, or as JSON with the json format.
+func (this *releasePlan) Write(w io.Writer, format string) error {
+	switch format {
+	case jsonOutput:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(this)
+	case "":
+		for i, wave := range this.Waves {
+			mode := "serial"
+			if wave.Concurrency > 1 {
+				mode = fmt.Sprintf("parallel, up to %d at a time", wave.Concurrency)
+			}
+			_, err := fmt.Fprintf(w, "wave %d (%s): %s\n", i+1, mode, strings.Join(wave.Services, ", "))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown output %q, expected %s", format, jsonOutput)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestReleasePlanWaves(t *testing.T) {
+	services := []GoServiceConfig{
+		{Name: "web", DependsOn: []string{"api"}},
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "worker", DependsOn: []string{"db"}},
+		{Name: "db"},
+		{Name: "cron", DependsOn: []string{"filtered-out"}},
+	}
+	waves, err := groupWaves(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := newReleasePlan(&GoServiceConfig{Name: "base"}, waves, 4)
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "text",
+			want: "wave 1 (serial): base\n" +
+				"wave 2 (parallel, up to 2 at a time): db, cron\n" +
+				"wave 3 (parallel, up to 2 at a time): api, worker\n" +
+				"wave 4 (serial): web\n",
+		},
+		{
+			name:   "json",
+			output: jsonOutput,
+			want: `{"max_go_routines":4,"waves":[` +
+				`{"services":["base"],"concurrency":1},` +
+				`{"services":["db","cron"],"concurrency":2},` +
+				`{"services":["api","worker"],"concurrency":2},` +
+				`{"services":["web"],"concurrency":1}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := plan.Write(&out, test.output); err != nil {
+				t.Fatal(err)
+			}
+			got := out.String()
+			if test.output == jsonOutput {
+				var compact bytes.Buffer
+				if err := json.Compact(&compact, out.Bytes()); err != nil {
+					t.Fatal(err)
+				}
+				got = compact.String()
+			}
+			if got != test.want {
+				t.Errorf("plan\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupWavesCycle(t *testing.T) {
+	_, err := groupWaves([]GoServiceConfig{
+		{Name: "api", DependsOn: []string{"web"}},
+		{Name: "web", DependsOn: []string{"api"}},
+	})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Errorf("error %v, want a dependency cycle config error", err)
+	}
+}
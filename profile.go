@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// BuildProfile holds the resources consumed while ko built a single service.
+// CPU time is read from the rusage of child processes (the `go build` invocations ko
+// spawns), so with concurrent builds the numbers of services building at the same time
+// bleed into each other. Use --max-go-routines 1 for exact figures. Memory can't be told
+// apart per build, it is reported release-wide as of the end of the build.
+type BuildProfile struct {
+	Service     string `json:"service"`
+	WallTimeMs  int64  `json:"wall_time_ms"`
+	UserCPUMs   int64  `json:"user_cpu_ms"`
+	SystemCPUMs int64  `json:"system_cpu_ms"`
+	// ReleaseChildMaxRSSKB is the peak RSS of the largest child process of the release so far
+	ReleaseChildMaxRSSKB int64 `json:"release_child_max_rss_kb"`
+	// ReleaseHeapAllocBytes is the heap of the ippon process
+	ReleaseHeapAllocBytes uint64 `json:"release_heap_alloc_bytes"`
+}
+
+type childUsage struct {
+	user   time.Duration
+	system time.Duration
+	maxRSS int64
+}
+
+type buildProfiler struct {
+	service string
+	start   time.Time
+	usage   childUsage
+}
+
+func startBuildProfile(service string) *buildProfiler {
+	return &buildProfiler{
+		service: service,
+		start:   time.Now(),
+		usage:   readChildUsage(),
+	}
+}
+
+func (this *buildProfiler) Stop() *BuildProfile {
+	wall := time.Since(this.start)
+	usage := readChildUsage()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return &BuildProfile{
+		Service:               this.service,
+		WallTimeMs:            wall.Milliseconds(),
+		UserCPUMs:             (usage.user - this.usage.user).Milliseconds(),
+		SystemCPUMs:           (usage.system - this.usage.system).Milliseconds(),
+		ReleaseChildMaxRSSKB:  usage.maxRSS,
+		ReleaseHeapAllocBytes: mem.HeapAlloc,
+	}
+}
+
+func (this *BuildProfile) String() string {
+	return fmt.Sprintf("wall=%dms user=%dms sys=%dms release_child_max_rss=%dKB release_heap=%dB",
+		this.WallTimeMs, this.UserCPUMs, this.SystemCPUMs, this.ReleaseChildMaxRSSKB, this.ReleaseHeapAllocBytes)
+}
+
+type buildProfiles struct {
+	mu       sync.Mutex
+	profiles []*BuildProfile
+}
+
+func (this *buildProfiles) Add(p *BuildProfile) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.profiles = append(this.profiles, p)
+}
+
+func (this *buildProfiles) WriteJSON(w io.Writer) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(this.profiles)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+func readChildUsage() childUsage {
+	return childUsage{}
+}
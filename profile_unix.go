@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func readChildUsage() childUsage {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &ru); err != nil {
+		return childUsage{}
+	}
+
+	// Maxrss is reported in bytes on macOS and in kilobytes everywhere else
+	maxRSS := int64(ru.Maxrss)
+	if runtime.GOOS == "darwin" {
+		maxRSS /= 1024
+	}
+
+	return childUsage{
+		user:   time.Duration(ru.Utime.Nano()),
+		system: time.Duration(ru.Stime.Nano()),
+		maxRSS: maxRSS,
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+type serviceStatus string
+
+const (
+	statusQueued   serviceStatus = "queued"
+	statusBuilding serviceStatus = "building"
+	statusPushing  serviceStatus = "pushing"
+	statusDone     serviceStatus = "done"
+	statusFailed   serviceStatus = "failed"
+)
+
+// progressReporter shows the status of every service of a release. On a terminal the
+// status table is redrawn in place, elsewhere each change is a timestamped line. A nil
+// reporter reports nothing.
+type progressReporter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	live     bool
+	services []string
+	statuses map[string]serviceStatus
+	drawn    int
+}
+
+// newProgressReporter reports to stderr, redrawn live when stderr is a terminal and no
+// verbose logs would interleave with the table.
+func newProgressReporter(services []string, verbose bool) *progressReporter {
+	this := &progressReporter{
+		out:      os.Stderr,
+		live:     !verbose && term.IsTerminal(int(os.Stderr.Fd())),
+		services: services,
+		statuses: map[string]serviceStatus{},
+	}
+	for _, service := range services {
+		this.statuses[service] = statusQueued
+	}
+	if this.live {
+		this.draw()
+	}
+	return this
+}
+
+func (this *progressReporter) Set(service string, status serviceStatus) {
+	if this == nil {
+		return
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.statuses[service] == status {
+		return
+	}
+	this.statuses[service] = status
+
+	if !this.live {
+		fmt.Fprintf(this.out, "%s ippon %s %s\n", time.Now().Format(time.RFC3339), service, status)
+		return
+	}
+	this.draw()
+}
+
+// draw rewrites the table over the previous one, the caller holding the lock.
+func (this *progressReporter) draw() {
+	var b strings.Builder
+	if this.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", this.drawn)
+	}
+
+	counts := map[serviceStatus]int{}
+	for _, service := range this.services {
+		status := this.statuses[service]
+		counts[status]++
+		fmt.Fprintf(&b, "\033[2K%-40s %s\n", service, status)
+	}
+	fmt.Fprintf(&b, "\033[2K%d/%d done, %d failed, %d in flight\n",
+		counts[statusDone], len(this.services), counts[statusFailed], counts[statusBuilding]+counts[statusPushing])
+
+	this.drawn = len(this.services) + 1
+	io.WriteString(this.out, b.String())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/lema-ai/ippon/registry"
+	"github.com/pkg/errors"
+)
+
+// newStagingTag returns the tag every image of a two-phase release is first pushed with.
+func newStagingTag() string {
+	return fmt.Sprintf("ippon-staging-%d", time.Now().Unix())
+}
+
+// promoteResults applies the final tags of every service to the digest pushed in the
+// staging phase, and the chart versions to the charts pushed under stagingTag. Nothing is
+// rebuilt, the tags are written against the existing manifests. Every tag is attempted,
+// the results record which image tags landed.
+func promoteResults(ctx context.Context, results []*ServiceResult, stagingTag string, options ...remote.Option) error {
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	failed := []string{}
+	for _, result := range results {
+		result.TagResults = []TagResult{}
+		for _, repository := range result.Repositories {
+			repo, err := name.NewRepository(repository)
+			if err != nil {
+				return errors.Wrapf(err, "parse repository of %s", result.Service)
+			}
+
+			desc, err := remote.Get(repo.Digest(result.Digest), options...)
+			if err != nil {
+				return errors.Wrapf(err, "get staged image of %s", result.Service)
+			}
+
+			tags := result.Tags
+			if repositoryTags, ok := result.RepositoryTags[repository]; ok {
+				tags = repositoryTags
+			}
+			for _, tag := range tags {
+				tagResult := TagResult{Repository: repository, Tag: tag}
+				err = remote.Tag(repo.Tag(tag), desc, options...)
+				if err != nil {
+					tagResult.Error = err.Error()
+					failed = append(failed, repository+":"+tag)
+					log.Printf("ippon failed promoting %s@%s to %s: %v\n", repository, result.Digest, tag, err)
+				} else {
+					log.Printf("ippon promoted %s@%s to %s\n", repository, result.Digest, tag)
+				}
+				result.TagResults = append(result.TagResults, tagResult)
+			}
+		}
+
+		for _, chart := range result.Charts {
+			if err := promoteChart(chart, stagingTag, options...); err != nil {
+				failed = append(failed, chart)
+				log.Printf("ippon failed promoting chart %s: %v\n", chart, err)
+			} else {
+				log.Printf("ippon promoted chart %s\n", chart)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("failed promoting %d tags: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// promoteChart tags the chart pushed under stagingTag with the version of chart.
+func promoteChart(chart, stagingTag string, options ...remote.Option) error {
+	ref, err := name.NewTag(chart)
+	if err != nil {
+		return errors.Wrap(err, "parse chart reference")
+	}
+	desc, err := remote.Get(ref.Context().Tag(stagingTag), options...)
+	if err != nil {
+		return errors.Wrap(err, "get staged chart")
+	}
+	return remote.Tag(ref, desc, options...)
+}
+
+// deleteStagingTags removes the staging tag of a promoted release from every image and
+// chart repository, the final tags now holding the images and charts. ECR repositories
+// are untagged through the ECR API, the others through the registry API, which not every
+// registry supports. Failures are only logged since the release itself succeeded.
+func deleteStagingTags(ctx context.Context, results []*ServiceResult, stagingTag string, ecr *registry.ECR, options ...remote.Option) {
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	for _, result := range results {
+		repositories := slices.Clone(result.Repositories)
+		for _, chart := range result.Charts {
+			if ref, err := name.NewTag(chart); err == nil {
+				repositories = append(repositories, ref.Context().Name())
+			}
+		}
+
+		for _, repository := range repositories {
+			var err error
+			if ecr != nil && strings.HasPrefix(repository, ecr.URL()+"/") {
+				err = ecr.DeleteTag(ctx, strings.TrimPrefix(repository, ecr.URL()+"/"), stagingTag)
+			} else {
+				var repo name.Repository
+				repo, err = name.NewRepository(repository)
+				if err == nil {
+					err = remote.Delete(repo.Tag(stagingTag), options...)
+				}
+			}
+			if err != nil {
+				log.Printf("ippon WARNING: failed deleting staging tag %s:%s: %v\n", repository, stagingTag, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPromoteDeletesStagingTag(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := name.NewRepository(u.Host + "/lema/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagingTag := newStagingTag()
+	if err := remote.Write(repo.Tag(stagingTag), img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chartDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: api\nversion: 0.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := readChartMetadata(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	chartRepo := u.Host + "/lema/charts/api"
+	chart, err := pushChart(ctx, chartRepo, chartDir, metadata, stagingTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []*ServiceResult{{
+		Service:      "api",
+		Digest:       digest.String(),
+		Tags:         []string{"v1", "latest"},
+		Repositories: []string{repo.String()},
+		Charts:       []string{chart},
+	}}
+	if err := promoteResults(ctx, results, stagingTag); err != nil {
+		t.Fatal(err)
+	}
+	deleteStagingTags(ctx, results, stagingTag, nil)
+
+	tags, err := remote.List(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(tags)
+	if want := []string{"latest", "v1"}; !slices.Equal(tags, want) {
+		t.Errorf("tags %v, want %v", tags, want)
+	}
+
+	promoted, err := remote.Head(repo.Tag("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Digest != digest {
+		t.Errorf("v1 digest %s, want %s", promoted.Digest, digest)
+	}
+
+	chartTags, err := remote.List(parseRepository(t, chartRepo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0.1.0"}; !slices.Equal(chartTags, want) {
+		t.Errorf("chart tags %v, want %v", chartTags, want)
+	}
+}
+
+func parseRepository(t *testing.T, s string) name.Repository {
+	t.Helper()
+	repo, err := name.NewRepository(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
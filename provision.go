@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lema-ai/ippon/registry"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	repoCreated = "created"
+	repoExisted = "existed"
+	repoMissing = "missing"
+	repoFailed  = "failed"
+)
+
+// repoProvision is the outcome of create-missing-repos for one repository. Dry runs
+// report missing where a real run reports created.
+type repoProvision struct {
+	Repository string `json:"repository"`
+	Status     string `json:"status"`
+	// PolicyApplied is set when the repository policy was applied to the repository
+	PolicyApplied bool   `json:"policy_applied,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type repoProvisions struct {
+	DryRun       bool             `json:"dry_run"`
+	Repositories []*repoProvision `json:"repositories"`
+}
+
+func (this *repoProvisions) Failed() []*repoProvision {
+	return lo.Filter(this.Repositories, func(r *repoProvision, _ int) bool {
+		return r.Status == repoFailed
+	})
+}
+
+func (this *repoProvisions) Print() {
+	for _, r := range this.Repositories {
+		if r.Error != "" {
+			fmt.Printf("%s: %s (%s)\n", r.Status, r.Repository, r.Error)
+			continue
+		}
+		if r.PolicyApplied {
+			fmt.Printf("%s: %s (policy applied)\n", r.Status, r.Repository)
+			continue
+		}
+		fmt.Printf("%s: %s\n", r.Status, r.Repository)
+	}
+}
+
+func (this *repoProvisions) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Provision checks the repositories up to maxGoRoutines at a time, creating the missing
+// ones unless dry running. When reconciling, the policy of ecr is applied to the existing
+// ones. Failures are recorded on their repository rather than returned.
+func (this *repoProvisions) Provision(ctx context.Context, repoRegistry CreateRepoRegistry, ecr *registry.ECR, reconcilePolicy bool, maxGoRoutines int) {
+	// created repositories get the policy as part of their creation
+	hasPolicy := ecr != nil && ecr.RepositoryPolicy() != ""
+
+	// each goroutine only touches its own provision, keeping the report in config order
+	g := errgroup.Group{}
+	g.SetLimit(maxGoRoutines)
+	for _, provision := range this.Repositories {
+		provision := provision
+		g.Go(func() error {
+			exists, err := repoRegistry.RepositoryExists(ctx, provision.Repository)
+			if err == nil && !exists {
+				provision.Status = repoMissing
+				if !this.DryRun {
+					err = repoRegistry.CreateRepository(ctx, provision.Repository)
+					provision.Status = repoCreated
+					provision.PolicyApplied = hasPolicy
+				}
+			} else if err == nil && reconcilePolicy && !this.DryRun {
+				err = ecr.ApplyRepositoryPolicy(ctx, provision.Repository)
+				provision.PolicyApplied = err == nil
+			}
+			if err != nil {
+				provision.Status = repoFailed
+				provision.Error = err.Error()
+				provision.PolicyApplied = false
+				return nil
+			}
+			if provision.Status == repoCreated {
+				log.Printf("repository created in registry: %s\n", provision.Repository)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeRepoRegistry holds repositories in memory, recording the ones created.
+type fakeRepoRegistry struct {
+	mu        sync.Mutex
+	repos     map[string]bool
+	failCheck map[string]bool
+	failNew   map[string]bool
+	created   []string
+}
+
+func (this *fakeRepoRegistry) Init(context.Context) error { return nil }
+func (this *fakeRepoRegistry) URL() string                { return "registry.example.com" }
+
+func (this *fakeRepoRegistry) RepositoryExists(_ context.Context, repo string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.failCheck[repo] {
+		return false, errors.New("check denied")
+	}
+	return this.repos[repo], nil
+}
+
+func (this *fakeRepoRegistry) CreateRepository(_ context.Context, repo string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.failNew[repo] {
+		return errors.New("create denied")
+	}
+	this.repos[repo] = true
+	this.created = append(this.created, repo)
+	return nil
+}
+
+func TestRepoProvisions(t *testing.T) {
+	tests := []struct {
+		name        string
+		dryRun      bool
+		failCheck   map[string]bool
+		failNew     map[string]bool
+		wantStatus  []string
+		wantCreated []string
+	}{
+		{
+			name:        "creates the missing repositories",
+			wantStatus:  []string{repoExisted, repoCreated, repoCreated},
+			wantCreated: []string{"dev/db", "dev/web"},
+		},
+		{
+			name:        "dry run only reports them",
+			dryRun:      true,
+			wantStatus:  []string{repoExisted, repoMissing, repoMissing},
+			wantCreated: []string{},
+		},
+		{
+			name:        "failures are recorded on their repository",
+			failCheck:   map[string]bool{"dev/api": true},
+			failNew:     map[string]bool{"dev/web": true},
+			wantStatus:  []string{repoFailed, repoCreated, repoFailed},
+			wantCreated: []string{"dev/db"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeRepoRegistry{
+				repos:     map[string]bool{"dev/api": true},
+				failCheck: test.failCheck,
+				failNew:   test.failNew,
+				created:   []string{},
+			}
+			provisions := &repoProvisions{DryRun: test.dryRun}
+			for _, repo := range []string{"dev/api", "dev/db", "dev/web"} {
+				provisions.Repositories = append(provisions.Repositories, &repoProvision{Repository: repo, Status: repoExisted})
+			}
+
+			provisions.Provision(context.Background(), fake, nil, false, 2)
+
+			status := []string{}
+			for _, provision := range provisions.Repositories {
+				status = append(status, provision.Status)
+				if (provision.Status == repoFailed) != (provision.Error != "") {
+					t.Errorf("%s status %s with error %q", provision.Repository, provision.Status, provision.Error)
+				}
+			}
+			if !slices.Equal(status, test.wantStatus) {
+				t.Errorf("status %v, want %v", status, test.wantStatus)
+			}
+			sort.Strings(fake.created)
+			if !slices.Equal(fake.created, test.wantCreated) {
+				t.Errorf("created %v, want %v", fake.created, test.wantCreated)
+			}
+			if len(provisions.Failed()) != len(test.failCheck)+len(test.failNew) {
+				t.Errorf("%d failed, want %d", len(provisions.Failed()), len(test.failCheck)+len(test.failNew))
+			}
+		})
+	}
+}
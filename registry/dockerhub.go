@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/ko/pkg/publish"
+	"github.com/pkg/errors"
+)
+
+const dockerHubHost = "docker.io"
+
+// DockerHub pushes under a Docker Hub namespace, a user or an organization.
+//
+// Docker Hub rate limits manifest pulls per account, or per IP for anonymous pulls, and a
+// release pulls the base image of every service. The token only authenticates pushes,
+// base images are pulled with the keychains (docker login), so releases of many services
+// off Docker Hub bases should run logged in or use mirrored bases to stay under the limit.
+type DockerHub struct {
+	namespace string
+	username  string
+	token     string
+}
+
+var (
+	_ CreateRepoRegistry = (*DockerHub)(nil)
+	_ SelfAuthRegistry   = (*DockerHub)(nil)
+	_ EnvRegistry        = (*DockerHub)(nil)
+)
+
+// NewDockerHub returns the registry of namespace, defaulting to the user's own namespace
+// when empty.
+func NewDockerHub(ctx context.Context, namespace string) (*DockerHub, error) {
+	d := &DockerHub{namespace: namespace}
+
+	err := d.Init(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (this *DockerHub) Init(ctx context.Context) error {
+	username, exists := os.LookupEnv("DOCKERHUB_USERNAME")
+	if !exists {
+		return errors.New("Failed getting Docker Hub's registry: DOCKERHUB_USERNAME not set")
+	}
+	this.username = username
+
+	token, exists := os.LookupEnv("DOCKERHUB_TOKEN")
+	if !exists {
+		return errors.New("Failed getting Docker Hub's registry: DOCKERHUB_TOKEN not set")
+	}
+	this.token = token
+
+	if this.namespace == "" {
+		this.namespace = username
+	}
+
+	return nil
+}
+
+// RequiredEnv lists the environment variables Init reads.
+func (this *DockerHub) RequiredEnv() [][]string {
+	return [][]string{
+		{"DOCKERHUB_USERNAME"},
+		{"DOCKERHUB_TOKEN"},
+	}
+}
+
+func (this *DockerHub) Authenticator() authn.Authenticator {
+	return &authn.Basic{
+		Username: this.username,
+		Password: this.token,
+	}
+}
+
+func (this *DockerHub) GetAuthOption() publish.Option {
+	return publish.WithAuth(this.Authenticator())
+}
+
+func (this *DockerHub) URL() string {
+	return fmt.Sprintf("%s/%s", dockerHubHost, this.namespace)
+}
+
+// RepositoryExists is always true, Docker Hub creates repositories on their first push,
+// private or public depending on the namespace's default visibility.
+func (this *DockerHub) RepositoryExists(_ context.Context, _ string) (bool, error) {
+	return true, nil
+}
+
+// CreateRepository does nothing, Docker Hub creates repositories on their first push.
+func (this *DockerHub) CreateRepository(_ context.Context, _ string) error {
+	return nil
+}
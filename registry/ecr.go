@@ -5,28 +5,116 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/pkg/errors"
 )
 
+// CreateRepositoryOptions configure the repositories ECR creates, the zero value creating
+// them with ECR's defaults.
+type CreateRepositoryOptions struct {
+	ImmutableTags bool
+	ScanOnPush    bool
+	// EncryptionType is AES256 or KMS, KMS when KMSKey is set and it is empty
+	EncryptionType string
+	// KMSKey is the KMS key ARN, alias or id, ECR's AWS managed key when empty
+	KMSKey string
+}
+
+// Validate checks the encryption settings are ones ECR accepts.
+func (this CreateRepositoryOptions) Validate() error {
+	switch types.EncryptionType(this.EncryptionType) {
+	case "", types.EncryptionTypeKms:
+		return nil
+	case types.EncryptionTypeAes256:
+		if this.KMSKey != "" {
+			return errors.New("kms_key requires KMS encryption")
+		}
+		return nil
+	default:
+		return errors.Errorf("encryption_type must be AES256 or KMS, got %s", this.EncryptionType)
+	}
+}
+
 type ECR struct {
 	accountId string
 	region    string
-	client    *ecr.Client
+	// roleArn, when set, is assumed with the default credentials for every ECR call
+	roleArn       string
+	createOptions CreateRepositoryOptions
+	policy        string
+	awsConfig     aws.Config
+	client        *ecr.Client
 }
 
-func NewECR(ctx context.Context, accountId, region string) (*ECR, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+var (
+	_ CreateRepoRegistry = (*ECR)(nil)
+	_ SelfAuthRegistry   = (*ECR)(nil)
+)
+
+// NewECR returns an initialized ECR client, using the default AWS credentials or, when
+// roleArn is set, the credentials of the role they assume.
+func NewECR(ctx context.Context, accountId, region, roleArn string) (*ECR, error) {
+	e := &ECR{
+		accountId: accountId,
+		region:    region,
+		roleArn:   roleArn,
+	}
+
+	err := e.Init(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ECR{
-		accountId: accountId,
-		region:    region,
-		client:    ecr.NewFromConfig(cfg),
-	}, nil
+	return e, nil
+}
+
+func (this *ECR) Init(ctx context.Context) error {
+	if this.client != nil {
+		return nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(this.region))
+	if err != nil {
+		return err
+	}
+
+	// the credential helper signs with the same config, publishing uses the role too
+	if this.roleArn != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), this.roleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "ippon"
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	this.awsConfig = cfg
+	this.client = ecr.NewFromConfig(cfg)
+	return nil
+}
+
+// SetImmutableTags makes repositories created from now on reject tag overwrites.
+func (this *ECR) SetImmutableTags(immutable bool) {
+	this.createOptions.ImmutableTags = immutable
+}
+
+// SetCreateRepositoryOptions configures the repositories created from now on.
+func (this *ECR) SetCreateRepositoryOptions(options CreateRepositoryOptions) {
+	this.createOptions = options
+}
+
+// SetRepositoryPolicy sets the JSON permissions policy applied to repositories created
+// from now on.
+func (this *ECR) SetRepositoryPolicy(policy string) {
+	this.policy = policy
+}
+
+// RepositoryPolicy returns the JSON permissions policy applied to created repositories.
+func (this *ECR) RepositoryPolicy() string {
+	return this.policy
 }
 
 func (this *ECR) AccountId() string {
@@ -65,6 +153,28 @@ func (this *ECR) RepositoryExists(ctx context.Context, repo string) (bool, error
 	return true, nil
 }
 
+// TagExists reports whether repo already has an image tagged tag.
+func (this *ECR) TagExists(ctx context.Context, repo, tag string) (bool, error) {
+	if this.client == nil {
+		return false, errors.New("ECR is not initialized")
+	}
+
+	params := &ecr.DescribeImagesInput{
+		RepositoryName: &repo,
+		ImageIds:       []types.ImageIdentifier{{ImageTag: &tag}},
+	}
+
+	_, err := this.client.DescribeImages(ctx, params)
+	if err != nil {
+		if strings.Contains(err.Error(), "ImageNotFoundException") || strings.Contains(err.Error(), "RepositoryNotFoundException") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (this *ECR) CreateRepository(ctx context.Context, repo string) error {
 	if this.client == nil {
 		return errors.New("ECR is not initialized")
@@ -77,7 +187,69 @@ func (this *ECR) createRepo(ctx context.Context, repo string) error {
 	params := &ecr.CreateRepositoryInput{
 		RepositoryName: &repo,
 	}
+	if this.createOptions.ImmutableTags {
+		params.ImageTagMutability = types.ImageTagMutabilityImmutable
+	}
+	if this.createOptions.ScanOnPush {
+		params.ImageScanningConfiguration = &types.ImageScanningConfiguration{ScanOnPush: true}
+	}
+	if this.createOptions.EncryptionType != "" || this.createOptions.KMSKey != "" {
+		encryptionType := types.EncryptionType(this.createOptions.EncryptionType)
+		if encryptionType == "" {
+			encryptionType = types.EncryptionTypeKms
+		}
+		params.EncryptionConfiguration = &types.EncryptionConfiguration{EncryptionType: encryptionType}
+		if this.createOptions.KMSKey != "" {
+			params.EncryptionConfiguration.KmsKey = &this.createOptions.KMSKey
+		}
+	}
 
 	_, err := this.client.CreateRepository(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	if this.policy == "" {
+		return nil
+	}
+	return errors.Wrap(this.ApplyRepositoryPolicy(ctx, repo), "set repository policy")
+}
+
+// ApplyRepositoryPolicy sets the configured permissions policy on an existing repository.
+func (this *ECR) ApplyRepositoryPolicy(ctx context.Context, repo string) error {
+	if this.client == nil {
+		return errors.New("ECR is not initialized")
+	}
+	if this.policy == "" {
+		return errors.New("no repository policy configured")
+	}
+
+	params := &ecr.SetRepositoryPolicyInput{
+		RepositoryName: &repo,
+		PolicyText:     &this.policy,
+	}
+
+	_, err := this.client.SetRepositoryPolicy(ctx, params)
 	return err
 }
+
+// DeleteTag removes tag from repo, leaving the image and its other tags in place.
+func (this *ECR) DeleteTag(ctx context.Context, repo, tag string) error {
+	if this.client == nil {
+		return errors.New("ECR is not initialized")
+	}
+
+	params := &ecr.BatchDeleteImageInput{
+		RepositoryName: &repo,
+		ImageIds:       []types.ImageIdentifier{{ImageTag: &tag}},
+	}
+
+	out, err := this.client.BatchDeleteImage(ctx, params)
+	if err != nil {
+		return err
+	}
+	if len(out.Failures) > 0 {
+		return errors.Errorf("%s: %s", out.Failures[0].FailureCode, aws.ToString(out.Failures[0].FailureReason))
+	}
+	return nil
+}
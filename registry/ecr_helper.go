@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/ko/pkg/publish"
+	"github.com/pkg/errors"
+)
+
+var ecrRegistryPattern = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+type ecrToken struct {
+	username  string
+	password  string
+	expiresAt time.Time
+}
+
+// ecrHelper implements the docker credential helper protocol (like amazon-ecr-credential-helper's
+// ecr-login) on top of the AWS config the ECR client was created with, so ECR registries
+// resolve without a docker config. Tokens are fetched per region and cached until they expire.
+type ecrHelper struct {
+	ecr    *ECR
+	mu     sync.Mutex
+	tokens map[string]ecrToken
+}
+
+func (this *ECR) CredentialHelper() authn.Helper {
+	return &ecrHelper{
+		ecr:    this,
+		tokens: map[string]ecrToken{},
+	}
+}
+
+// Authenticator authenticates pushes with the registry's ECR authorization token, fetched
+// with the AWS credentials and refreshed as it expires, so publishing needs no prior
+// docker login.
+func (this *ECR) Authenticator() authn.Authenticator {
+	return &ecrAuthenticator{
+		helper:    this.CredentialHelper(),
+		serverURL: this.URL(),
+	}
+}
+
+func (this *ECR) GetAuthOption() publish.Option {
+	return publish.WithAuth(this.Authenticator())
+}
+
+type ecrAuthenticator struct {
+	helper    authn.Helper
+	serverURL string
+}
+
+func (this *ecrAuthenticator) Authorization() (*authn.AuthConfig, error) {
+	username, password, err := this.helper.Get(this.serverURL)
+	if err != nil {
+		return nil, err
+	}
+	return &authn.AuthConfig{Username: username, Password: password}, nil
+}
+
+func (this *ecrHelper) Get(serverURL string) (string, string, error) {
+	host := strings.TrimPrefix(strings.TrimPrefix(serverURL, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	match := ecrRegistryPattern.FindStringSubmatch(host)
+	if match == nil {
+		return "", "", errors.Errorf("%s is not an ECR registry", serverURL)
+	}
+	region := match[1]
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if token, ok := this.tokens[region]; ok && time.Now().Before(token.expiresAt) {
+		return token.username, token.password, nil
+	}
+
+	if this.ecr.client == nil {
+		return "", "", errors.New("ECR is not initialized")
+	}
+
+	client := this.ecr.client
+	if region != this.ecr.region {
+		client = ecr.NewFromConfig(this.ecr.awsConfig, func(o *ecr.Options) {
+			o.Region = region
+		})
+	}
+
+	out, err := client.GetAuthorizationToken(context.Background(), &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return "", "", errors.Wrap(err, "get ECR authorization token")
+	}
+	if len(out.AuthorizationData) == 0 || out.AuthorizationData[0].AuthorizationToken == nil {
+		return "", "", errors.New("ECR returned no authorization data")
+	}
+
+	data := out.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(*data.AuthorizationToken)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decode ECR authorization token")
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", errors.New("malformed ECR authorization token")
+	}
+
+	token := ecrToken{
+		username: username,
+		password: password,
+		// refresh a bit before the token actually expires
+		expiresAt: time.Now().Add(time.Hour),
+	}
+	if data.ExpiresAt != nil {
+		token.expiresAt = data.ExpiresAt.Add(-5 * time.Minute)
+	}
+	this.tokens[region] = token
+
+	return token.username, token.password, nil
+}
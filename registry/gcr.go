@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/ko/pkg/publish"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	artifactRegistryAPI = "https://artifactregistry.googleapis.com/v1"
+	gcpScope            = "https://www.googleapis.com/auth/cloud-platform"
+	// gcpTokenUsername is the username Artifact Registry expects along an OAuth access token
+	gcpTokenUsername = "oauth2accesstoken"
+)
+
+// ArtifactRegistry is a GCP Artifact Registry docker repository. Images live under the
+// docker repository and are created on push, so the repositories ippon checks and creates
+// are the docker repository itself.
+type ArtifactRegistry struct {
+	project    string
+	location   string
+	repository string
+	client     *http.Client
+
+	mu          sync.Mutex
+	tokenSource oauth2.TokenSource
+}
+
+var (
+	_ CreateRepoRegistry = (*ArtifactRegistry)(nil)
+	_ SelfAuthRegistry   = (*ArtifactRegistry)(nil)
+)
+
+func NewArtifactRegistry(ctx context.Context, project, location, repository string) (*ArtifactRegistry, error) {
+	a := &ArtifactRegistry{
+		project:    project,
+		location:   location,
+		repository: repository,
+	}
+
+	err := a.Init(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Init checks the registry settings. Credentials are only looked up once a repository
+// call or a push needs a token, so commands not talking to the registry don't need them.
+func (this *ArtifactRegistry) Init(ctx context.Context) error {
+	if this.project == "" || this.location == "" || this.repository == "" {
+		return errors.New("Artifact Registry needs a project, a location and a repository")
+	}
+	if this.client == nil {
+		this.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return nil
+}
+
+// accessToken returns GOOGLE_OAUTH_ACCESS_TOKEN when set (as printed by gcloud auth
+// print-access-token), or else a token of the application default credentials: the
+// GOOGLE_APPLICATION_CREDENTIALS file, gcloud auth application-default login, or the
+// GCE/GKE metadata server. Tokens are refreshed as they expire.
+func (this *ArtifactRegistry) accessToken() (string, error) {
+	if token, exists := os.LookupEnv("GOOGLE_OAUTH_ACCESS_TOKEN"); exists {
+		return token, nil
+	}
+
+	this.mu.Lock()
+	if this.tokenSource == nil {
+		// the token source outlives the call it is created in, refreshing with the registry's client
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, this.client)
+		creds, err := google.FindDefaultCredentials(ctx, gcpScope)
+		if err != nil {
+			this.mu.Unlock()
+			return "", errors.Wrap(err, "Failed getting GCP credentials: GOOGLE_OAUTH_ACCESS_TOKEN not set and no application default credentials")
+		}
+		this.tokenSource = oauth2.ReuseTokenSource(nil, creds.TokenSource)
+	}
+	tokenSource := this.tokenSource
+	this.mu.Unlock()
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed getting a GCP access token")
+	}
+	return token.AccessToken, nil
+}
+
+func (this *ArtifactRegistry) Project() string {
+	return this.project
+}
+
+func (this *ArtifactRegistry) Location() string {
+	return this.location
+}
+
+func (this *ArtifactRegistry) URL() string {
+	return fmt.Sprintf("%s-docker.pkg.dev/%s/%s", this.location, this.project, this.repository)
+}
+
+// Authenticator refreshes the access token as it expires, releases outliving the hour
+// access tokens last.
+func (this *ArtifactRegistry) Authenticator() authn.Authenticator {
+	return &gcpAuthenticator{registry: this}
+}
+
+func (this *ArtifactRegistry) GetAuthOption() publish.Option {
+	return publish.WithAuth(this.Authenticator())
+}
+
+func (this *ArtifactRegistry) repositoryName() string {
+	return fmt.Sprintf("projects/%s/locations/%s/repositories/%s", this.project, this.location, this.repository)
+}
+
+// RepositoryExists reports whether the docker repository exists, images under it being
+// created on push.
+func (this *ArtifactRegistry) RepositoryExists(ctx context.Context, _ string) (bool, error) {
+	resp, err := this.call(ctx, http.MethodGet, "/"+this.repositoryName(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, apiError(resp)
+	}
+}
+
+// CreateRepository creates the docker repository images are pushed under.
+func (this *ArtifactRegistry) CreateRepository(ctx context.Context, _ string) error {
+	body, err := json.Marshal(map[string]string{"format": "DOCKER"})
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/projects/%s/locations/%s/repositories?repositoryId=%s", this.project, this.location, url.QueryEscape(this.repository))
+	resp, err := this.call(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// another release may have created it in the meantime
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return apiError(resp)
+	}
+	return nil
+}
+
+func (this *ArtifactRegistry) call(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	if this.client == nil {
+		return nil, errors.New("Artifact Registry is not initialized")
+	}
+
+	token, err := this.accessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, artifactRegistryAPI+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return this.client.Do(req)
+}
+
+type gcpAuthenticator struct {
+	registry *ArtifactRegistry
+}
+
+func (this *gcpAuthenticator) Authorization() (*authn.AuthConfig, error) {
+	token, err := this.registry.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	return &authn.AuthConfig{Username: gcpTokenUsername, Password: token}, nil
+}
+
+func apiError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return errors.Errorf("Artifact Registry API: %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// serviceAccountKey writes a service account key whose tokens come from tokenURL.
+func serviceAccountKey(t *testing.T, tokenURL string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "ippon",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "ippon@ippon.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestArtifactRegistryApplicationDefaultCredentials(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "adc-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", serviceAccountKey(t, server.URL))
+
+	a, err := NewArtifactRegistry(context.Background(), "ippon", "europe-west1", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("Init fetched %d tokens, want none", calls.Load())
+	}
+
+	// the token is fetched once and reused until it expires
+	for i := 0; i < 2; i++ {
+		auth, err := a.Authenticator().Authorization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.Username != gcpTokenUsername || auth.Password != "adc-token" {
+			t.Errorf("authorization %s:%s, want %s:adc-token", auth.Username, auth.Password, gcpTokenUsername)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("fetched %d tokens, want 1", calls.Load())
+	}
+}
+
+func TestArtifactRegistryAccessTokenOverride(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "printed-token")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	a, err := NewArtifactRegistry(context.Background(), "ippon", "europe-west1", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := a.Authenticator().Authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Password != "printed-token" {
+		t.Errorf("password %s, want printed-token", auth.Password)
+	}
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/ko/pkg/publish"
+	"github.com/pkg/errors"
+)
+
+const ghcrHost = "ghcr.io"
+
+// GHCR is the GitHub Container Registry, pushing under the packages of an owner.
+type GHCR struct {
+	owner string
+	token string
+}
+
+var (
+	_ CreateRepoRegistry = (*GHCR)(nil)
+	_ SelfAuthRegistry   = (*GHCR)(nil)
+	_ EnvRegistry        = (*GHCR)(nil)
+)
+
+// NewGHCR returns the registry of owner, defaulting to GITHUB_REPOSITORY_OWNER when empty.
+func NewGHCR(ctx context.Context, owner string) (*GHCR, error) {
+	g := &GHCR{owner: owner}
+
+	err := g.Init(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Init picks up the token from GHCR_TOKEN, for a personal access token with the
+// write:packages scope, or else GITHUB_TOKEN, as set in GitHub Actions. The owner, also
+// the basic auth username, is the configured one or GITHUB_REPOSITORY_OWNER.
+func (this *GHCR) Init(ctx context.Context) error {
+	if this.owner == "" {
+		owner, exists := os.LookupEnv("GITHUB_REPOSITORY_OWNER")
+		if !exists {
+			return errors.New("Failed getting ghcr's owner: ghcr.owner and GITHUB_REPOSITORY_OWNER not set")
+		}
+		this.owner = owner
+	}
+
+	token, exists := os.LookupEnv("GHCR_TOKEN")
+	if !exists {
+		token, exists = os.LookupEnv("GITHUB_TOKEN")
+	}
+	if !exists {
+		return errors.New("Failed getting ghcr's token: GHCR_TOKEN or GITHUB_TOKEN not set")
+	}
+	this.token = token
+
+	return nil
+}
+
+// RequiredEnv lists the environment variables Init reads.
+func (this *GHCR) RequiredEnv() [][]string {
+	return [][]string{
+		{"GHCR_TOKEN", "GITHUB_TOKEN"},
+	}
+}
+
+func (this *GHCR) Authenticator() authn.Authenticator {
+	return &authn.Basic{
+		Username: this.owner,
+		Password: this.token,
+	}
+}
+
+func (this *GHCR) GetAuthOption() publish.Option {
+	return publish.WithAuth(this.Authenticator())
+}
+
+// URL returns the owner's namespace, lowercased since image references can't hold
+// uppercase letters.
+func (this *GHCR) URL() string {
+	return fmt.Sprintf("%s/%s", ghcrHost, strings.ToLower(this.owner))
+}
+
+// RepositoryExists is always true, ghcr creates packages on their first push.
+func (this *GHCR) RepositoryExists(_ context.Context, _ string) (bool, error) {
+	return true, nil
+}
+
+// CreateRepository does nothing, ghcr creates packages on their first push.
+func (this *GHCR) CreateRepository(_ context.Context, _ string) error {
+	return nil
+}
@@ -4,19 +4,27 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/ko/pkg/publish"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 )
 
 type Okteto struct {
 	registryUrl string
-	namespace   string
+	namespaces  []string
 	username    string
 	token       string
 }
 
+var (
+	_ SelfAuthRegistry = (*Okteto)(nil)
+	_ EnvRegistry      = (*Okteto)(nil)
+	_ MultiURLRegistry = (*Okteto)(nil)
+)
+
 func (this *Okteto) Init(ctx context.Context) error {
 	registryUrl, exists := os.LookupEnv("OKTETO_REGISTRY_URL")
 	if !exists {
@@ -24,11 +32,20 @@ func (this *Okteto) Init(ctx context.Context) error {
 	}
 	this.registryUrl = registryUrl
 
-	namespace, exists := os.LookupEnv("OKTETO_NAMESPACE")
+	// OKTETO_NAMESPACES (or a comma separated OKTETO_NAMESPACE) pushes every image to several namespaces
+	namespaces, exists := os.LookupEnv("OKTETO_NAMESPACES")
+	if !exists {
+		namespaces, exists = os.LookupEnv("OKTETO_NAMESPACE")
+	}
 	if !exists {
 		return errors.New("Failed getting Okteto's registry: OKTETO_NAMESPACE not set")
 	}
-	this.namespace = namespace
+	this.namespaces = lo.Compact(lo.Map(strings.Split(namespaces, ","), func(ns string, _ int) string {
+		return strings.TrimSpace(ns)
+	}))
+	if len(this.namespaces) == 0 {
+		return errors.New("Failed getting Okteto's registry: OKTETO_NAMESPACE is empty")
+	}
 
 	username, exists := os.LookupEnv("OKTETO_USERNAME")
 	if !exists {
@@ -45,13 +62,44 @@ func (this *Okteto) Init(ctx context.Context) error {
 	return nil
 }
 
-func (this *Okteto) GetAuthOption() publish.Option {
-	return publish.WithAuth(&authn.Basic{
+// RequiredEnv lists the environment variables Init reads.
+func (this *Okteto) RequiredEnv() [][]string {
+	return [][]string{
+		{"OKTETO_REGISTRY_URL"},
+		{"OKTETO_NAMESPACES", "OKTETO_NAMESPACE"},
+		{"OKTETO_USERNAME"},
+		{"OKTETO_TOKEN"},
+	}
+}
+
+func (this *Okteto) Authenticator() authn.Authenticator {
+	return &authn.Basic{
 		Username: this.username,
 		Password: this.token,
-	})
+	}
 }
 
+func (this *Okteto) GetAuthOption() publish.Option {
+	return publish.WithAuth(this.Authenticator())
+}
+
+// Namespaces returns the namespaces images are pushed to, the primary one first.
+func (this *Okteto) Namespaces() []string {
+	return this.namespaces
+}
+
+// URL returns the registry path of the first namespace.
 func (this *Okteto) URL() string {
-	return fmt.Sprintf("%s/%s", this.registryUrl, this.namespace)
+	return this.namespaceURL(this.namespaces[0])
+}
+
+// URLs returns the registry path of every namespace images are pushed to.
+func (this *Okteto) URLs() []string {
+	return lo.Map(this.namespaces, func(ns string, _ int) string {
+		return this.namespaceURL(ns)
+	})
+}
+
+func (this *Okteto) namespaceURL(namespace string) string {
+	return fmt.Sprintf("%s/%s", this.registryUrl, namespace)
 }
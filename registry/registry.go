@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/ko/pkg/publish"
+)
+
+// The interfaces the commands use the registries through. Each registry asserts the
+// ones it implements next to its type.
+
+type Registry interface {
+	Init(context.Context) error
+	URL() string
+}
+
+type CreateRepoRegistry interface {
+	Registry
+	RepositoryExists(ctx context.Context, repo string) (bool, error)
+	CreateRepository(ctx context.Context, repo string) error
+}
+
+type SelfAuthRegistry interface {
+	Registry
+	Authenticator() authn.Authenticator
+	GetAuthOption() publish.Option
+}
+
+// EnvRegistry declares the environment variables Init needs, each entry listing
+// alternative names of which one must be set.
+type EnvRegistry interface {
+	Registry
+	RequiredEnv() [][]string
+}
+
+// MultiURLRegistry pushes every image to several locations, URL being the primary one.
+type MultiURLRegistry interface {
+	Registry
+	URLs() []string
+}
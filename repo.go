@@ -3,42 +3,247 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
+	"os/signal"
 	"path"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/publish"
+	"github.com/lema-ai/ippon/backend"
+	"github.com/lema-ai/ippon/registry"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"github.com/sigstore/cosign/v2/pkg/oci"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/errgroup"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/semaphore"
 )
 
-func buildAndPublishGoService(ctx context.Context, cmdDir, serviceName, baseURL, baseImage, namespace string, tags []string, publishAuthOption publish.Option, remoteAuthOption remote.Option) (*Image, error) {
-	b, err := build.NewGo(ctx, cmdDir,
-		build.WithPlatforms("linux/amd64"),
-		build.WithDisabledSBOM(),
-		build.WithBaseImages(func(ctx context.Context, _ string) (name.Reference, build.Result, error) {
-			baseImage = strings.ReplaceAll(baseImage, "BASE_URL", baseURL)
-			ref, err := name.ParseReference(baseImage)
+// releaseSettings holds what every service build of a release shares.
+type releaseSettings struct {
+	baseURL        string
+	extraBaseURLs  []string
+	namespace      string
+	builder        string
+	publisher      string
+	publishOptions []publish.Option
+	remoteOptions  []remote.Option
+	profiles       *buildProfiles
+	attachments    []attachSpec
+	goVersion      string
+	basePulls      *semaphore.Weighted
+	buildRetries   int
+	// publishRetries is how many times a push failing on throttling or server errors is retried
+	publishRetries      int
+	publishRetryBackoff time.Duration
+	debugShell          string
+	// stagingTag replaces the service tags while publishing, they are applied once every service is pushed
+	stagingTag string
+	sbomDir    string
+	// sbom pushes the SPDX SBOM ko generates next to the image
+	sbom         bool
+	registryTags []RegistryTagsConfig
+	// maxBaseAge, when set, warns about or fails builds on older base images
+	maxBaseAge          time.Duration
+	failOnStaleBase     bool
+	platformConcurrency int
+	// labels go on the config of every image
+	labels map[string]string
+	// gitAnnotations go on every image, below the configured annotations
+	gitAnnotations map[string]string
+	// digestTag, when set, is the format of an extra tag derived from the image digest
+	digestTag string
+	// platforms, from --platform, replace the configured platforms of every service
+	platforms []string
+	// git is the .Git of ldflags templates, only resolved when a service has ldflags
+	git *gitInfo
+	// progress, with --progress, shows which services are building and pushing
+	progress *progressReporter
+}
+
+// ldflagsFor returns the expanded ldflags of service.
+func (this *releaseSettings) ldflagsFor(service GoServiceConfig) ([]string, error) {
+	ldflags := service.GetLdflags()
+	if len(ldflags) == 0 {
+		return nil, nil
+	}
+	return expandLdflags(ldflags, this.git)
+}
+
+// platformsFor returns the platforms to build service for.
+func (this *releaseSettings) platformsFor(service GoServiceConfig) []string {
+	if len(this.platforms) > 0 {
+		return this.platforms
+	}
+	return service.GetPlatforms()
+}
+
+// baseURLs returns every base URL images are pushed under, the primary one last.
+func (this *releaseSettings) baseURLs() []string {
+	return append(append([]string{}, this.extraBaseURLs...), this.baseURL)
+}
+
+// tagsFor returns the tags to push under baseURL, the registry_tags entry matching it
+// if any, tags otherwise.
+func (this *releaseSettings) tagsFor(baseURL string, tags []string) []string {
+	for _, registryTags := range this.registryTags {
+		if baseURL != registryTags.Registry && !strings.HasPrefix(baseURL, registryTags.Registry+"/") {
+			continue
+		}
+		overrides := registryTags.Tags
+		if this.debugShell != "" {
+			overrides = debugTags(overrides)
+		}
+		return overrides
+	}
+	return tags
+}
+
+// ServiceResult is what releasing a single service produced.
+type ServiceResult struct {
+	Service string
+	Image   *Image
+	Digest  string
+	Tags    []string
+	// Channels lists the floating tags among Tags
+	Channels []string
+	// Repositories lists every repository the image was pushed to
+	Repositories []string
+	// RepositoryTags holds the tags of repositories pushed with other tags than Tags
+	RepositoryTags map[string][]string
+	Attachments    []*Attachment
+	// TagResults tells which tags landed, the staging tag until a two phase release is promoted
+	TagResults []TagResult
+	// Charts lists the references of the service's Helm chart, one per registry
+	Charts    []string
+	GoVersion string
+	// Profile is the build profile of the service, nil unless --profile-builds
+	Profile *BuildProfile
+	// Duration is how long building and pushing took, 0 when skipped
+	Duration time.Duration
+}
+
+func buildAndPublishGoService(ctx context.Context, settings *releaseSettings, service GoServiceConfig, baseImage string, tags []string, logger *log.Logger) (*ServiceResult, error) {
+	serviceName := service.Name
+
+	newBuilder, err := backend.GetBuilder(settings.builder)
+	if err != nil {
+		return nil, err
+	}
+
+	// builders get the base images resolved, whatever the builder
+	baseImage = resolveBaseImage(baseImage, settings.baseURL)
+
+	// several platforms make ko push an index, whose digest the manifest points at
+	platforms := settings.platformsFor(service)
+	platformBaseImages := service.GetPlatformBaseImages()
+	if len(platformBaseImages) > 0 {
+		missing := lo.Filter(platforms, func(platform string, _ int) bool {
+			_, ok := platformBaseImages[platform]
+			return !ok
+		})
+		if len(missing) > 0 {
+			return nil, errors.Errorf("no base image for platforms %s", strings.Join(missing, ", "))
+		}
+		platformBaseImages = lo.MapValues(platformBaseImages, func(baseImage string, _ string) string {
+			return resolveBaseImage(baseImage, settings.baseURL)
+		})
+	}
+
+	if settings.maxBaseAge > 0 {
+		bases := platformBaseImages
+		if len(bases) == 0 {
+			bases = lo.SliceToMap(platforms, func(platform string) (string, string) {
+				return platform, baseImage
+			})
+		}
+		for platform, base := range bases {
+			err = checkBaseAge(base, platform, settings.maxBaseAge, settings.failOnStaleBase, append([]remote.Option{remote.WithContext(ctx)}, settings.remoteOptions...)...)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
-			base, err := remote.Index(ref, remote.WithContext(ctx), remoteAuthOption)
-			return ref, base, err
-		}),
-	)
+		}
+	}
+
+	ldflags, err := settings.ldflagsFor(service)
 	if err != nil {
-		return nil, errors.Wrap(err, "build go image")
+		return nil, err
 	}
 
-	r, err := b.Build(ctx, "")
+	b, err := newBuilder(ctx, backend.BuildOptions{
+		Dir:                 service.GetMainDir(),
+		Platforms:           platforms,
+		BaseImage:           baseImage,
+		PlatformBaseImages:  platformBaseImages,
+		SBOM:                settings.sbom || settings.sbomDir != "",
+		RemoteOptions:       settings.remoteOptions,
+		BasePulls:           settings.basePulls,
+		PlatformConcurrency: settings.platformConcurrency,
+		Labels:              settings.labels,
+		Ldflags:             ldflags,
+	})
+	if err != nil {
+		return nil, &BuildError{Service: serviceName, Err: errors.Wrap(err, "build go image")}
+	}
+
+	var profiler *buildProfiler
+	var profile *BuildProfile
+	if settings.profiles != nil {
+		profiler = startBuildProfile(serviceName)
+	}
+
+	var r build.Result
+	err = withRetries(ctx, "build of "+serviceName, settings.buildRetries, buildRetryBackoff, isTransientBuildError, func() error {
+		r, err = b.Build(ctx, "")
+		return err
+	})
+	if err != nil {
+		return nil, &BuildError{Service: serviceName, Err: errors.Wrap(err, "build image")}
+	}
+
+	if profiler != nil {
+		profile = profiler.Stop()
+		logger.Printf("ippon build profile for %s: %s\n", serviceName, profile)
+		settings.profiles.Add(profile)
+	}
+
+	// the SBOM is taken off the result while ippon mutates it, and put back if pushed
+	var sbom oci.File
+	if settings.sbomDir != "" || settings.sbom {
+		sbom = resultSBOM(r)
+		r = withoutAttachments(r)
+	}
+
+	if settings.debugShell != "" {
+		r, err = withDebugEntrypoint(r, settings.debugShell)
+		if err != nil {
+			return nil, errors.Wrap(err, "set debug entrypoint")
+		}
+		tags = debugTags(tags)
+	}
+
+	r, err = annotateResult(r, lo.Assign(settings.gitAnnotations, service.GetIndexAnnotations()), service.GetManifestAnnotations())
 	if err != nil {
-		return nil, errors.Wrap(err, "build image")
+		return nil, errors.Wrap(err, "annotate image")
+	}
+
+	if settings.sbom && sbom != nil {
+		r, err = withSBOM(r, sbom)
+		if err != nil {
+			return nil, errors.Wrap(err, "attach SBOM")
+		}
 	}
 
 	digest, err := r.Digest()
@@ -46,126 +251,1297 @@ func buildAndPublishGoService(ctx context.Context, cmdDir, serviceName, baseURL,
 		return nil, errors.Wrap(err, "get image digest")
 	}
 
-	p, err := publish.NewDefault(baseURL,
-		publish.WithTags(tags),
-		publishAuthOption,
-	)
+	if settings.sbomDir != "" && sbom != nil {
+		payload, err := sbom.Payload()
+		if err != nil {
+			return nil, errors.Wrap(err, "get SBOM")
+		}
+		err = writeSBOMFile(settings.sbomDir, serviceName, payload, digest.String())
+		if err != nil {
+			return nil, errors.Wrap(err, "write SBOM")
+		}
+	}
+
+	newPublisher, err := backend.GetPublisher(settings.publisher)
 	if err != nil {
-		return nil, errors.Wrap(err, "authenticate to image repo")
+		return nil, err
 	}
 
-	repoName := serviceName
-	if namespace != "" {
-		repoName = path.Join(namespace, serviceName)
+	settings.progress.Set(serviceName, statusPushing)
+
+	withDigestTag := func(tags []string) []string {
+		if tag := digestTag(settings.digestTag, digest); tag != "" && !lo.Contains(tags, tag) {
+			return append(append([]string{}, tags...), tag)
+		}
+		return tags
 	}
+	tags = withDigestTag(tags)
 
-	c, err := publish.NewCaching(p)
+	repoName, err := service.RepoName(settings.namespace)
 	if err != nil {
-		return nil, errors.Wrap(err, "create caching publisher")
+		return nil, err
+	}
+
+	// the default publisher lower cases repository names
+	repository := func(baseURL string) string {
+		return path.Join(baseURL, strings.ToLower(repoName))
 	}
 
-	ref, err := c.Publish(ctx, r, repoName)
+	// the multi publisher returns the reference of the last publisher, keep the primary URL last
+	publishers := []publish.Interface{}
+	repositoryTags := map[string][]string{}
+	// pushedTags are the tags pushed now per repository, the staging tag in two phase releases
+	pushedTags := map[string][]string{}
+	for _, baseURL := range settings.baseURLs() {
+		publishTags := withDigestTag(settings.tagsFor(baseURL, tags))
+		if !slices.Equal(publishTags, tags) {
+			repositoryTags[repository(baseURL)] = publishTags
+		}
+		if settings.stagingTag != "" {
+			publishTags = []string{settings.stagingTag}
+		}
+		pushedTags[repository(baseURL)] = publishTags
+
+		p, err := newPublisher(ctx, backend.PublishOptions{
+			BaseURL:        baseURL,
+			Tags:           publishTags,
+			PublishOptions: settings.publishOptions,
+		})
+		if err != nil {
+			return nil, &RegistryAuthError{Registry: baseURL, Err: errors.Wrap(err, "authenticate to image repo")}
+		}
+		publishers = append(publishers, p)
+	}
+
+	p := publishers[0]
+	if len(publishers) > 1 {
+		p = publish.MultiPublisher(publishers...)
+	}
+
+	var ref name.Reference
+	err = withRetries(ctx, "push of "+serviceName, settings.publishRetries, settings.publishRetryBackoff, isTransientPublishError, func() error {
+		ref, err = p.Publish(ctx, r, repoName)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "publish image")
+		return nil, &PublishError{
+			Service: serviceName,
+			Err:     errors.Wrap(err, "publish image"),
+			Tags:    checkTagResults(ctx, pushedTags, digest, settings.remoteOptions...),
+		}
+	}
+
+	attachments := make([]*Attachment, 0, len(settings.attachments))
+	for _, spec := range settings.attachments {
+		attachment, err := attachArtifact(ctx, ref.Context(), r, spec, serviceName, settings.remoteOptions...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "attach %s", spec.artifactType)
+		}
+		logger.Printf("ippon attached %s to %s: %s\n", attachment.Path, serviceName, attachment.Digest)
+		attachments = append(attachments, attachment)
 	}
 
-	return &Image{
-		OldName: fmt.Sprintf("registry.lema.ai/%s", serviceName),
-		NewName: fmt.Sprintf("%s@%s", ref.Context().Name(), digest),
+	charts := []string{}
+	// charts are only pushed to registries
+	if service.Chart != "" && settings.publisher != backend.LocalPublisher {
+		metadata, err := readChartMetadata(service.Chart)
+		if err != nil {
+			return nil, &PublishError{Service: serviceName, Err: errors.Wrap(err, "publish chart")}
+		}
+		chartRepo, err := chartRepoName(service, settings.namespace, metadata.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, baseURL := range settings.baseURLs() {
+			var chart string
+			err = withRetries(ctx, "push of the chart of "+serviceName, settings.publishRetries, settings.publishRetryBackoff, isTransientPublishError, func() error {
+				chart, err = pushChart(ctx, path.Join(baseURL, chartRepo), service.Chart, metadata, settings.stagingTag, settings.remoteOptions...)
+				return err
+			})
+			if err != nil {
+				return nil, &PublishError{Service: serviceName, Err: errors.Wrap(err, "publish chart")}
+			}
+			logger.Printf("ippon pushed chart of %s: %s\n", serviceName, chart)
+			charts = append(charts, chart)
+		}
+	}
+
+	newName := fmt.Sprintf("%s@%s", ref.Context().Name(), digest)
+	// publishers returning a tag, like the local daemon one, loaded the image under it
+	if _, ok := ref.(name.Tag); ok {
+		newName = ref.Name()
+	}
+
+	return &ServiceResult{
+		Service: serviceName,
+		Image: &Image{
+			OldName: service.OldImageName(),
+			NewName: newName,
+		},
+		Digest: digest.String(),
+		Tags:   tags,
+		Repositories: lo.Map(settings.baseURLs(), func(baseURL string, _ int) string {
+			return repository(baseURL)
+		}),
+		RepositoryTags: repositoryTags,
+		Attachments:    attachments,
+		Charts:         charts,
+		TagResults:     pushedTagResults(pushedTags),
+		GoVersion:      settings.goVersion,
+		Profile:        profile,
 	}, nil
 }
 
 func registryCommand(ctx context.Context, cmd *cobra.Command, _ []string, registryName string) error {
-	configPath, err := cmd.Flags().GetString("config")
+	configPaths, err := cmd.Flags().GetStringArray("config")
 	if err != nil {
 		return errors.Wrap(err, "failed getting config flag")
 	}
 
-	config, err := getConfig(registryName, configPath)
+	output, err := cmd.Flags().GetString("output")
 	if err != nil {
-		return errors.Wrap(err, "get services config")
+		return errors.Wrap(err, "failed getting output flag")
+	}
+	if output != "" && output != jsonOutput {
+		return errors.Errorf("unknown output %q, expected %s", output, jsonOutput)
+	}
+	// stdout is for the summary, verbose logs move to stderr
+	if output == jsonOutput && log.Writer() == os.Stdout {
+		log.SetOutput(os.Stderr)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return errors.Wrap(err, "failed getting timeout flag")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// the first SIGINT or SIGTERM cancels in-flight builds and pushes, a second one kills ippon
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	ephemeral, err := cmd.Flags().GetBool("ephemeral-registry")
+	if err != nil {
+		return errors.Wrap(err, "failed getting ephemeral-registry flag")
+	}
+
+	local, err := cmd.Flags().GetBool("local")
+	if err != nil {
+		return errors.Wrap(err, "failed getting local flag")
+	}
+	if local && ephemeral {
+		return errors.New("--local and --ephemeral-registry are different push targets")
+	}
+
+	var config *Config
+	if local {
+		config, err = getRegistrylessConfig(configPaths)
+		if err != nil {
+			return errors.Wrap(err, "get services config")
+		}
+		config.Registry = localRegistry{}
+		config.Publisher = backend.LocalPublisher
+	} else if ephemeral {
+		config, err = getRegistrylessConfig(configPaths)
+		if err != nil {
+			return errors.Wrap(err, "get services config")
+		}
+		ephemeralRegistry := newEphemeralRegistry()
+		defer ephemeralRegistry.Close()
+		config.Registry = ephemeralRegistry
+	} else {
+		config, err = getConfig(registryName, configPaths)
+		if err != nil {
+			return errors.Wrap(err, "get services config")
+		}
+	}
+	if err := config.Validate(registryName); err != nil {
+		return err
+	}
+
+	// waves cover every configured service, the manifest holding the filtered out ones too
+	allServices := config.ServicesConfig.GoServices
+	waves, err := serviceWaves(allServices)
+	if err != nil {
+		return err
+	}
+
+	keychain, err := buildKeychain(config.Keychains, config.ECR)
+	if err != nil {
+		return errors.Wrap(err, "build registry keychain")
+	}
+	publishAuthOption := publish.WithAuthFromKeychain(keychain)
+	if selfAuth, ok := config.Registry.(SelfAuthRegistry); ok {
+		publishAuthOption = selfAuth.GetAuthOption()
+		keychain, err = withRegistryAuth(keychain, selfAuth)
+		if err != nil {
+			return err
+		}
 	}
+	remoteAuthOption := remote.WithAuthFromKeychain(keychain)
 
-	publishAuthOption := publish.WithAuthFromKeychain(authn.DefaultKeychain)
-	remoteAuthOption := remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	var extraBaseURLs []string
+	if multi, ok := config.Registry.(MultiURLRegistry); ok {
+		extraBaseURLs = lo.Without(multi.URLs(), multi.URL())
+	}
 	maxGoRoutines, err := cmd.Flags().GetInt("max-go-routines")
 	if err != nil {
 		return errors.Wrap(err, "failed getting max-go-routines flag")
 	}
+	// builds are CPU bound, more concurrent builds than CPUs only interleave them
+	if !cmd.Flags().Changed("max-go-routines") {
+		maxGoRoutines = runtime.NumCPU()
+	}
+
+	serial, err := cmd.Flags().GetBool("serial")
+	if err != nil {
+		return errors.Wrap(err, "failed getting serial flag")
+	}
+	if serial {
+		maxGoRoutines = 1
+	}
+	if maxGoRoutines < 1 {
+		return errors.Errorf("max-go-routines must be at least 1, got %d", maxGoRoutines)
+	}
+	log.Printf("ippon building up to %d services concurrently\n", maxGoRoutines)
 
 	namespace, err := cmd.Flags().GetString("namespace")
 	if err != nil {
 		return errors.Wrap(err, "failed getting namespace flag")
 	}
 
-	imagesChan := make(chan *Image, len(config.ServicesConfig.GoServices))
-	g := errgroup.Group{}
-	g.SetLimit(maxGoRoutines)
+	// the okteto registry URL already holds the namespace, only the manifest defaults to it
+	manifestNamespace := namespace
+	if okteto, ok := config.Registry.(*registry.Okteto); ok && !cmd.Flags().Changed("namespace") {
+		manifestNamespace = okteto.Namespaces()[0]
+		log.Printf("ippon updating the manifest of okteto namespace %s\n", manifestNamespace)
+	}
+
+	profileBuilds, err := cmd.Flags().GetBool("profile-builds")
+	if err != nil {
+		return errors.Wrap(err, "failed getting profile-builds flag")
+	}
 
-	for _, service := range config.ServicesConfig.GoServices {
-		service := service
-		g.Go(func() error {
-			log.Printf("ippon building go service: %+v\n", service)
-			baseURL := config.ECR.URL()
-			tags := service.GetTags()
-			baseImage := service.GetBaseImage()
-
-			image, err := buildAndPublishGoService(ctx, service.Main, service.Name, baseURL, baseImage, namespace, tags, publishAuthOption, remoteAuthOption)
-			if err != nil {
-				return errors.Wrap(err, "build and push go service")
-			}
+	var profiles *buildProfiles
+	if profileBuilds {
+		profiles = &buildProfiles{}
+	}
 
-			imagesChan <- image
-			return nil
+	setNames, err := cmd.Flags().GetStringSlice("set")
+	if err != nil {
+		return errors.Wrap(err, "failed getting set flag")
+	}
+
+	var setServices []string
+	if len(setNames) > 0 {
+		releaseSetsFile, err := cmd.Flags().GetString("release-sets-file")
+		if err != nil {
+			return errors.Wrap(err, "failed getting release-sets-file flag")
+		}
+		setServices, err = getReleaseSetServices(releaseSetsFile, setNames, config.ServicesConfig.GoServices)
+		if err != nil {
+			return err
+		}
+	}
+
+	onlyServices, err := cmd.Flags().GetStringSlice("services")
+	if err != nil {
+		return errors.Wrap(err, "failed getting services flag")
+	}
+	if err := checkServicesExist(config.ServicesConfig.GoServices, onlyServices); err != nil {
+		return err
+	}
+
+	excluded, err := getExcludedServices(cmd)
+	if err != nil {
+		return errors.Wrap(err, "get excluded services")
+	}
+	config.ServicesConfig.GoServices = filterServices(config.ServicesConfig.GoServices, excluded)
+
+	if len(onlyServices) > 0 {
+		config.ServicesConfig.GoServices = lo.Filter(config.ServicesConfig.GoServices, func(s GoServiceConfig, _ int) bool {
+			return lo.Contains(onlyServices, s.Name)
 		})
 	}
 
-	if err := g.Wait(); err != nil {
-		return errors.Wrap(err, "fatal error while building service")
+	if len(setNames) > 0 {
+		config.ServicesConfig.GoServices = lo.Filter(config.ServicesConfig.GoServices, func(s GoServiceConfig, _ int) bool {
+			return lo.Contains(setServices, s.Name)
+		})
 	}
-	close(imagesChan)
 
-	if namespace == "" {
-		return nil
+	match, err := cmd.Flags().GetString("match")
+	if err != nil {
+		return errors.Wrap(err, "failed getting match flag")
+	}
+	if match != "" {
+		config.ServicesConfig.GoServices, err = matchServices(config.ServicesConfig.GoServices, match)
+		if err != nil {
+			return err
+		}
 	}
-	return updateK8sDeployment(namespace, imagesChan)
-}
 
-func createMissingReposCommand(ctx context.Context, cmd *cobra.Command, _ []string, registryName string) error {
-	configPath, err := cmd.Flags().GetString("config")
+	warmup, err := cmd.Flags().GetString("warmup-service")
 	if err != nil {
-		return errors.Wrap(err, "failed getting config flag")
+		return errors.Wrap(err, "failed getting warmup-service flag")
 	}
-	config, err := getConfig(registryName, configPath)
+	if !cmd.Flags().Changed("warmup-service") {
+		warmup = viper.GetString("cache_warmup_service")
+	}
+
+	warmupService, services, err := releaseOrder(config.ServicesConfig.GoServices, warmup, viper.GetBool("warmup_strict"), maxGoRoutines == 1)
 	if err != nil {
-		return errors.Wrap(err, "get services config")
+		return err
 	}
 
-	namespace, err := cmd.Flags().GetString("namespace")
+	shuffle, err := cmd.Flags().GetBool("shuffle-order")
 	if err != nil {
-		return errors.Wrap(err, "failed getting namespace flag")
+		return errors.Wrap(err, "failed getting shuffle-order flag")
 	}
 
-	serviceNames := lo.Map(config.ServicesConfig.GoServices, func(s GoServiceConfig, _ int) string {
-		return s.Name
-	})
+	if shuffle {
+		seed, err := cmd.Flags().GetInt64("shuffle-seed")
+		if err != nil {
+			return errors.Wrap(err, "failed getting shuffle-seed flag")
+		}
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		log.Printf("ippon shuffling build order with seed %d\n", seed)
+		shuffleServices(services, seed)
+	}
+
+	// services build in waves following depends_on, dependencies first
+	buildWaves, err := groupWaves(services)
+	if err != nil {
+		return err
+	}
+
+	plan, err := cmd.Flags().GetBool("plan")
+	if err != nil {
+		return errors.Wrap(err, "failed getting plan flag")
+	}
+	// the plan is a dry run, it returns before anything touches git, the toolchain, the
+	// registry or the checkpoint
+	if plan {
+		return newReleasePlan(warmupService, buildWaves, maxGoRoutines).Write(os.Stdout, output)
+	}
+
+	requireCleanTree, err := cmd.Flags().GetBool("require-clean-tree")
+	if err != nil {
+		return errors.Wrap(err, "failed getting require-clean-tree flag")
+	}
 
-	for _, repo := range serviceNames {
-		if namespace != "" {
-			repo = path.Join(namespace, repo)
+	allowDirty, err := cmd.Flags().GetBool("allow-dirty")
+	if err != nil {
+		return errors.Wrap(err, "failed getting allow-dirty flag")
+	}
+
+	// git derived tags of images built from a dirty tree are suffixed, they don't match the commit
+	var dirtySuffix string
+	if requireCleanTree {
+		dirty, err := gitTreeDirty(ctx)
+		if err != nil {
+			return errors.Wrap(err, "check git tree")
+		}
+		if dirty && !allowDirty {
+			return errors.New("git tree has uncommitted changes, commit them or pass --allow-dirty")
+		}
+		if dirty {
+			log.Printf("ippon releasing from a dirty git tree\n")
+			dirtySuffix = "-dirty"
+		}
+	}
+
+	useGitTags, err := cmd.Flags().GetBool("git-tags")
+	if err != nil {
+		return errors.Wrap(err, "failed getting git-tags flag")
+	}
+	if useGitTags {
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			return errors.Wrap(err, "failed getting branch flag")
 		}
-		exists, err := config.ECR.RepositoryExists(ctx, repo)
+		// the git tags stand in for the global tags when the config has none
+		tags, err := gitTags(ctx, branch, dirtySuffix)
 		if err != nil {
+			log.Printf("ippon WARNING: skipping git tags, not in a git repository: %v\n", err)
+		} else {
+			viper.SetDefault("tags", tags)
+		}
+	}
+
+	requireTags, err := cmd.Flags().GetBool("require-tags")
+	if err != nil {
+		return errors.Wrap(err, "failed getting require-tags flag")
+	}
+
+	if requireTags {
+		untagged := lo.FilterMap(config.ServicesConfig.GoServices, func(s GoServiceConfig, _ int) (string, bool) {
+			return s.Name, len(s.GetTags()) == 0
+		})
+		if len(untagged) > 0 {
+			return errors.Errorf("services without tags: %s", strings.Join(untagged, ", "))
+		}
+	}
+
+	immutableTags, err := cmd.Flags().GetBool("immutable-tags")
+	if err != nil {
+		return errors.Wrap(err, "failed getting immutable-tags flag")
+	}
+
+	if immutableTags || viper.GetBool(registrySection(registryName)+".immutable_tags") {
+		if config.ECR == nil {
+			return errors.Errorf("immutable tags are only supported on ECR")
+		}
+		if err := checkTagsUnused(ctx, config.ECR, config.ServicesConfig.GoServices, namespace); err != nil {
 			return err
 		}
+	}
+
+	latestGuard, err := cmd.Flags().GetBool("tag-latest-only-on-default-branch")
+	if err != nil {
+		return errors.Wrap(err, "failed getting tag-latest-only-on-default-branch flag")
+	}
+
+	allowLatest := true
+	if latestGuard {
+		allowLatest, err = isDefaultBranch(ctx, cmd)
+		if err != nil {
+			return errors.Wrap(err, "resolve git branch")
+		}
+	}
+
+	channelsGuard, err := cmd.Flags().GetBool("channels-only-on-default-branch")
+	if err != nil {
+		return errors.Wrap(err, "failed getting channels-only-on-default-branch flag")
+	}
+
+	allowChannels := true
+	if channelsGuard {
+		allowChannels, err = isDefaultBranch(ctx, cmd)
+		if err != nil {
+			return errors.Wrap(err, "resolve git branch")
+		}
+	}
+
+	useBranchTag, err := cmd.Flags().GetBool("branch-tag")
+	if err != nil {
+		return errors.Wrap(err, "failed getting branch-tag flag")
+	}
 
-		if !exists {
-			err := config.ECR.CreateRepository(ctx, repo)
+	var extraTags []string
+	if useBranchTag {
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			return errors.Wrap(err, "failed getting branch flag")
+		}
+		if branch == "" {
+			branch, err = currentGitBranch(ctx)
 			if err != nil {
-				return err
+				return errors.Wrap(err, "resolve git branch")
 			}
-			log.Printf("repository created in registry: %s\n", repo)
 		}
+		tag, err := branchTag(branch)
+		if err != nil {
+			return err
+		}
+		tag = dirtyTag(tag, dirtySuffix)
+		log.Printf("ippon tagging images with branch tag %s\n", tag)
+		extraTags = append(extraTags, tag)
 	}
-	return nil
+
+	attachValues, err := cmd.Flags().GetStringArray("attach")
+	if err != nil {
+		return errors.Wrap(err, "failed getting attach flag")
+	}
+
+	attachments, err := parseAttachSpecs(attachValues)
+	if err != nil {
+		return err
+	}
+	if local && len(attachments) > 0 {
+		return errors.New("--attach pushes to a registry, it can't be used with --local")
+	}
+
+	basePullConcurrency, err := cmd.Flags().GetInt64("base-pull-concurrency")
+	if err != nil {
+		return errors.Wrap(err, "failed getting base-pull-concurrency flag")
+	}
+	if basePullConcurrency < 1 {
+		return errors.New("base-pull-concurrency must be at least 1")
+	}
+
+	headerValues, err := cmd.Flags().GetStringArray("registry-header")
+	if err != nil {
+		return errors.Wrap(err, "failed getting registry-header flag")
+	}
+
+	headers, err := parseRegistryHeaders(viper.GetStringMapString("registry_headers"), headerValues)
+	if err != nil {
+		return err
+	}
+
+	transport := remote.DefaultTransport
+	if len(headers) > 0 {
+		transport = &headerTransport{headers: headers, inner: transport}
+	}
+
+	buildRetries, err := cmd.Flags().GetInt("build-retries")
+	if err != nil {
+		return errors.Wrap(err, "failed getting build-retries flag")
+	}
+
+	publishRetries, err := cmd.Flags().GetInt("publish-retries")
+	if err != nil {
+		return errors.Wrap(err, "failed getting publish-retries flag")
+	}
+
+	publishRetryBackoff, err := cmd.Flags().GetDuration("publish-retry-backoff")
+	if err != nil {
+		return errors.Wrap(err, "failed getting publish-retry-backoff flag")
+	}
+
+	debugEntrypoint, err := cmd.Flags().GetBool("debug-entrypoint")
+	if err != nil {
+		return errors.Wrap(err, "failed getting debug-entrypoint flag")
+	}
+
+	var debugShell string
+	if debugEntrypoint {
+		debugShell = viper.GetString("debug_shell")
+		log.Printf("ippon building debug images with %s entrypoint\n", debugShell)
+	}
+
+	twoPhase, err := cmd.Flags().GetBool("two-phase")
+	if err != nil {
+		return errors.Wrap(err, "failed getting two-phase flag")
+	}
+
+	if local && twoPhase {
+		return errors.New("--two-phase retags images in a registry, it can't be used with --local")
+	}
+
+	var stagingTag string
+	if twoPhase {
+		stagingTag = newStagingTag()
+		log.Printf("ippon pushing with staging tag %s\n", stagingTag)
+	}
+
+	sbomDir, err := cmd.Flags().GetString("sbom-dir")
+	if err != nil {
+		return errors.Wrap(err, "failed getting sbom-dir flag")
+	}
+
+	sbom, err := cmd.Flags().GetBool("sbom")
+	if err != nil {
+		return errors.Wrap(err, "failed getting sbom flag")
+	}
+	if !cmd.Flags().Changed("sbom") {
+		sbom = viper.GetBool("sbom")
+	}
+
+	registryTags, err := getRegistryTags()
+	if err != nil {
+		return err
+	}
+
+	var maxBaseAge time.Duration
+	if value := viper.GetString("max_base_age"); value != "" {
+		maxBaseAge, err = time.ParseDuration(value)
+		if err != nil {
+			return errors.Wrap(err, "invalid max_base_age")
+		}
+	}
+
+	failOnStaleBase, err := cmd.Flags().GetBool("fail-on-stale-base")
+	if err != nil {
+		return errors.Wrap(err, "failed getting fail-on-stale-base flag")
+	}
+
+	labels, err := freshnessLabels(time.Now())
+	if err != nil {
+		return err
+	}
+
+	noGitAnnotations, err := cmd.Flags().GetBool("no-git-annotations")
+	if err != nil {
+		return errors.Wrap(err, "failed getting no-git-annotations flag")
+	}
+
+	var annotationsFromGit map[string]string
+	if !noGitAnnotations {
+		annotationsFromGit = gitAnnotations(ctx)
+	}
+
+	digestTagFormat := viper.GetString("digest_tag")
+	if err := validateDigestTagFormat(digestTagFormat); err != nil {
+		return err
+	}
+
+	platformConcurrency, err := cmd.Flags().GetInt("platform-concurrency")
+	if err != nil {
+		return errors.Wrap(err, "failed getting platform-concurrency flag")
+	}
+	if platformConcurrency < 0 {
+		return errors.Errorf("platform-concurrency must be at least 0, got %d", platformConcurrency)
+	}
+
+	platforms, err := cmd.Flags().GetStringSlice("platform")
+	if err != nil {
+		return errors.Wrap(err, "failed getting platform flag")
+	}
+
+	goVersion, err := useGoToolchain(ctx, viper.GetString("go_version"))
+	if err != nil {
+		return errors.Wrap(err, "set go toolchain")
+	}
+	log.Printf("ippon building with %s\n", goVersion)
+
+	// outside of a git repository only ldflags referring to .Git fail
+	var git *gitInfo
+	if lo.SomeBy(config.ServicesConfig.GoServices, func(s GoServiceConfig) bool { return len(s.GetLdflags()) > 0 }) {
+		git, err = readGitInfo(ctx)
+		if err != nil {
+			log.Printf("ippon ldflags can't refer to .Git: %v\n", err)
+		}
+	}
+
+	settings := &releaseSettings{
+		baseURL:             config.Registry.URL(),
+		extraBaseURLs:       extraBaseURLs,
+		namespace:           namespace,
+		builder:             config.Builder,
+		publisher:           config.Publisher,
+		publishOptions:      []publish.Option{publishAuthOption, publish.WithTransport(transport)},
+		remoteOptions:       []remote.Option{remoteAuthOption, remote.WithTransport(transport)},
+		profiles:            profiles,
+		attachments:         attachments,
+		goVersion:           goVersion,
+		basePulls:           semaphore.NewWeighted(basePullConcurrency),
+		buildRetries:        buildRetries,
+		publishRetries:      publishRetries,
+		publishRetryBackoff: publishRetryBackoff,
+		debugShell:          debugShell,
+		registryTags:        registryTags,
+		maxBaseAge:          maxBaseAge,
+		failOnStaleBase:     failOnStaleBase,
+		platformConcurrency: platformConcurrency,
+		platforms:           platforms,
+		git:                 git,
+		digestTag:           digestTagFormat,
+		gitAnnotations:      annotationsFromGit,
+		labels:              labels,
+		stagingTag:          stagingTag,
+		sbomDir:             sbomDir,
+		sbom:                sbom,
+	}
+
+	reportFile, err := cmd.Flags().GetString("report-file")
+	if err != nil {
+		return errors.Wrap(err, "failed getting report-file flag")
+	}
+	report := &releaseReport{}
+
+	printURLs, err := cmd.Flags().GetBool("print-urls")
+	if err != nil {
+		return errors.Wrap(err, "failed getting print-urls flag")
+	}
+
+	urlsFile, err := cmd.Flags().GetString("urls-file")
+	if err != nil {
+		return errors.Wrap(err, "failed getting urls-file flag")
+	}
+
+	imagesOutput, err := cmd.Flags().GetString("images-output")
+	if err != nil {
+		return errors.Wrap(err, "failed getting images-output flag")
+	}
+	if imagesOutput == "-" && output == jsonOutput {
+		return errors.New("--images-output - and --output json both write to stdout")
+	}
+
+	deploymentFormat, err := cmd.Flags().GetString("deployment-format")
+	if err != nil {
+		return errors.Wrap(err, "failed getting deployment-format flag")
+	}
+
+	var helmValues *HelmValuesConfig
+	switch deploymentFormat {
+	case manifestDeploymentFormat:
+	case helmDeploymentFormat:
+		helmValues, err = getHelmValuesConfig()
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unknown deployment format %q, expected %s or %s", deploymentFormat, manifestDeploymentFormat, helmDeploymentFormat)
+	}
+
+	kustomization, err := cmd.Flags().GetString("kustomization")
+	if err != nil {
+		return errors.Wrap(err, "failed getting kustomization flag")
+	}
+
+	legacyImagesFormat, err := cmd.Flags().GetBool("legacy-images-format")
+	if err != nil {
+		return errors.Wrap(err, "failed getting legacy-images-format flag")
+	}
+
+	imagesOutputDir, err := cmd.Flags().GetString("images-output-dir")
+	if err != nil {
+		return errors.Wrap(err, "failed getting images-output-dir flag")
+	}
+	if imagesOutputDir != "" {
+		viper.Set("images_output_dir", imagesOutputDir)
+	}
+
+	noManifest, err := cmd.Flags().GetBool("no-manifest")
+	if err != nil {
+		return errors.Wrap(err, "failed getting no-manifest flag")
+	}
+
+	resume, err := cmd.Flags().GetBool("resume")
+	if err != nil {
+		return errors.Wrap(err, "failed getting resume flag")
+	}
+
+	if !resume {
+		if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove stale checkpoint")
+		}
+	}
+
+	releaseCheckpoint, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+
+	onlyChanged, err := cmd.Flags().GetBool("only-changed-config")
+	if err != nil {
+		return errors.Wrap(err, "failed getting only-changed-config flag")
+	}
+
+	var cache *buildCache
+	if onlyChanged {
+		cache, err = loadBuildCache(buildCachePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	progress, err := cmd.Flags().GetBool("progress")
+	if err != nil {
+		return errors.Wrap(err, "failed getting progress flag")
+	}
+	if progress {
+		names := lo.Map(services, func(s GoServiceConfig, _ int) string { return s.Name })
+		if warmupService != nil {
+			names = append([]string{warmupService.Name}, names...)
+		}
+		settings.progress = newProgressReporter(names, log.Writer() != io.Discard)
+	}
+
+	manifestNamespaces, err := cmd.Flags().GetStringSlice("manifest-namespace")
+	if err != nil {
+		return errors.Wrap(err, "failed getting manifest-namespace flag")
+	}
+
+	manifestYq, err := cmd.Flags().GetString("manifest-yq")
+	if err != nil {
+		return errors.Wrap(err, "failed getting manifest-yq flag")
+	}
+
+	orderHintsFormat, err := cmd.Flags().GetString("manifest-order-hints")
+	if err != nil {
+		return errors.Wrap(err, "failed getting manifest-order-hints flag")
+	}
+
+	var hints func(*Images)
+	if orderHintsFormat != "" {
+		servicesByName := lo.KeyBy(allServices, func(s GoServiceConfig) string {
+			return s.Name
+		})
+		hints, err = orderHints(orderHintsFormat, lo.MapKeys(waves, func(_ int, service string) string {
+			return servicesByName[service].OldImageName()
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	logDir, err := cmd.Flags().GetString("log-dir")
+	if err != nil {
+		return errors.Wrap(err, "failed getting log-dir flag")
+	}
+
+	failFast, err := cmd.Flags().GetBool("fail-fast")
+	if err != nil {
+		return errors.Wrap(err, "failed getting fail-fast flag")
+	}
+
+	keepGoing, err := cmd.Flags().GetBool("keep-going")
+	if err != nil {
+		return errors.Wrap(err, "failed getting keep-going flag")
+	}
+	if keepGoing {
+		failFast = false
+	}
+
+	verifyManifest, err := cmd.Flags().GetBool("verify-manifest")
+	if err != nil {
+		return errors.Wrap(err, "failed getting verify-manifest flag")
+	}
+	if local && verifyManifest {
+		return errors.New("--verify-manifest resolves images from their registry, it can't be used with --local")
+	}
+
+	if (manifestYq != "" || verifyManifest) && !noManifest && manifestNamespace == "" && len(manifestNamespaces) == 0 {
+		return errors.New("--manifest-yq and --verify-manifest need --namespace or --manifest-namespace")
+	}
+
+	resultsChan := make(chan *ServiceResult, len(config.ServicesConfig.GoServices))
+	// with --fail-fast, the first failed service cancels releaseCtx and with it the others
+	releaseCtx, cancelRelease := context.WithCancel(ctx)
+	defer cancelRelease()
+
+	releaseService := func(service GoServiceConfig) (err error) {
+		started := time.Now()
+		logger, closeLog, err := serviceLogger(logDir, service.Name)
+		if err != nil {
+			report.Add(service.Name, time.Since(started), err)
+			return err
+		}
+		defer func() {
+			if err != nil {
+				logger.Printf("ippon failed releasing %s: %v\n", service.Name, err)
+				report.Add(service.Name, time.Since(started), err)
+				settings.progress.Set(service.Name, statusFailed)
+			} else {
+				settings.progress.Set(service.Name, statusDone)
+			}
+			closeLog()
+		}()
+
+		if result, ok := releaseCheckpoint.Resume(releaseCtx, service.Name, settings.remoteOptions...); ok {
+			logger.Printf("ippon skipping %s, already published in checkpoint: %s\n", service.Name, result.Image.NewName)
+			report.Skip(service.Name, "already published in checkpoint")
+			// the old image prefix may have changed since
+			result.Image.OldName = service.OldImageName()
+			resultsChan <- result
+			return nil
+		}
+
+		settings.progress.Set(service.Name, statusBuilding)
+		logger.Printf("ippon building go service: %+v\n", service)
+		tags := service.GetTags()
+		if !allowLatest && lo.Contains(tags, latestTag) {
+			logger.Printf("ippon skipping %q tag for %s: not on the default branch\n", latestTag, service.Name)
+			tags = lo.Without(tags, latestTag)
+		}
+		channels := service.Channels
+		if !allowChannels && len(channels) > 0 {
+			logger.Printf("ippon skipping channels %v for %s: not on the default branch\n", channels, service.Name)
+			channels = nil
+		}
+		tags = lo.Uniq(append(append(append([]string{}, tags...), extraTags...), channels...))
+		baseImage := service.GetBaseImage()
+
+		var configHash, sourceHash string
+		if cache != nil {
+			var err error
+			configHash, err = serviceConfigHash(service, settings, tags, baseImage)
+			if err != nil {
+				return errors.Wrap(err, "hash service config")
+			}
+			sourceHash, err = serviceSourceHash(releaseCtx, service.GetMainDir())
+			if err != nil {
+				return errors.Wrap(err, "hash service source")
+			}
+			if result, ok := cache.Lookup(service.Name, configHash, sourceHash); ok {
+				logger.Printf("ippon skipping %s, source and config unchanged: %s\n", service.Name, result.Image.NewName)
+				report.Skip(service.Name, "source and config unchanged")
+				result.Image.OldName = service.OldImageName()
+				resultsChan <- result
+				return nil
+			}
+		}
+
+		start := time.Now()
+		result, err := buildAndPublishGoService(releaseCtx, settings, service, baseImage, tags, logger)
+		if err != nil {
+			return errors.Wrap(err, "build and push go service")
+		}
+		report.Add(service.Name, time.Since(start), nil)
+		result.Duration = time.Since(start)
+		result.Channels = channels
+		if settings.debugShell != "" {
+			result.Channels = debugTags(channels)
+		}
+
+		if cache != nil {
+			cache.Record(result, configHash, sourceHash)
+		}
+
+		if err := releaseCheckpoint.Record(result); err != nil {
+			logger.Printf("ippon failed checkpointing %s: %v\n", service.Name, err)
+		}
+
+		logger.Printf("ippon released %s in %s: %s\n", service.Name, time.Since(start).Round(time.Second), result.Image.NewName)
+		resultsChan <- result
+		return nil
+	}
+
+	var failuresMu sync.Mutex
+	failures := []ServiceFailure{}
+	// cancelled reports whether another service failing fast cancelled the release, the
+	// errors of the services in flight then coming from the cancellation
+	cancelled := func() bool {
+		return ctx.Err() == nil && releaseCtx.Err() != nil
+	}
+	runService := func(service GoServiceConfig) error {
+		err := releaseService(service)
+		if err != nil && cancelled() {
+			log.Printf("ippon cancelled %s after another service failed\n", service.Name)
+			return err
+		}
+		if err != nil {
+			failuresMu.Lock()
+			failures = append(failures, ServiceFailure{Service: service.Name, Err: err})
+			failuresMu.Unlock()
+		}
+		return err
+	}
+
+	runServices(warmupService, buildWaves, maxGoRoutines, failFast, cancelRelease, runService)
+	var releaseErr error
+	if len(failures) > 0 {
+		sort.Slice(failures, func(i, j int) bool {
+			return failures[i].Service < failures[j].Service
+		})
+		released := len(services)
+		if warmupService != nil {
+			released++
+		}
+		releaseErr = &ReleaseError{Failures: failures, Services: released}
+	}
+	if reportFile != "" {
+		// the services a failure or an interruption stopped the release before
+		reason := "another service failed"
+		if ctx.Err() != nil {
+			reason = "release interrupted"
+		}
+		names := lo.Map(services, func(service GoServiceConfig, _ int) string {
+			return service.Name
+		})
+		if warmupService != nil {
+			names = append(names, warmupService.Name)
+		}
+		report.NotStarted(names, reason)
+
+		if err := report.Write(reportFile); err != nil {
+			return errors.Wrap(err, "write report file")
+		}
+	}
+	close(resultsChan)
+	results := lo.ChannelToSlice(resultsChan)
+	if releaseErr != nil && ctx.Err() != nil {
+		published := lo.Map(results, func(result *ServiceResult, _ int) string {
+			return result.Service
+		})
+		sort.Strings(published)
+		return errors.Wrapf(releaseErr, "release interrupted (%v), published before: [%s], rerun with --resume to skip them",
+			ctx.Err(), strings.Join(published, ", "))
+	}
+	if releaseErr != nil {
+		return errors.Wrap(releaseErr, "fatal error while building service")
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Service < results[j].Service
+	})
+
+	if twoPhase {
+		if err := promoteResults(ctx, results, stagingTag, settings.remoteOptions...); err != nil {
+			return errors.Wrap(err, "promote staged images")
+		}
+		deleteStagingTags(ctx, results, stagingTag, config.ECR, settings.remoteOptions...)
+	}
+
+	if err := releaseCheckpoint.Clear(); err != nil {
+		return errors.Wrap(err, "clear checkpoint")
+	}
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			return errors.Wrap(err, "save build cache")
+		}
+	}
+
+	// bom_repository gets a bill of materials image referencing every service's image
+	var bomRef string
+	if bomRepo := viper.GetString("bom_repository"); bomRepo != "" && local {
+		log.Printf("ippon WARNING: not pushing the release bill of materials, images were loaded locally\n")
+	} else if bomRepo != "" {
+		bomRef, err = pushReleaseBOM(ctx, settings.baseURL, bomRepo, GoServiceConfig{}.GetTags(), results, settings.remoteOptions...)
+		if err != nil {
+			return errors.Wrap(err, "push release bill of materials")
+		}
+	}
+
+	if output == jsonOutput {
+		if err := writeReleaseSummary(os.Stdout, results, bomRef); err != nil {
+			return errors.Wrap(err, "write release summary")
+		}
+	} else {
+		if printURLs || ephemeral {
+			if err := writeImageURLs(os.Stdout, results, false); err != nil {
+				return errors.Wrap(err, "print image urls")
+			}
+		}
+		if bomRef != "" {
+			fmt.Printf("bom: %s\n", bomRef)
+		}
+	}
+
+	if urlsFile != "" {
+		if err := writeImageURLsFile(urlsFile, results); err != nil {
+			return errors.Wrap(err, "write image urls file")
+		}
+	}
+
+	if imagesOutput == "-" {
+		err = writeImageURLs(os.Stdout, results, true)
+	} else if imagesOutput != "" {
+		err = writeImageURLsJSONFile(imagesOutput, results)
+	}
+	if err != nil {
+		return errors.Wrap(err, "write images output")
+	}
+
+	if profiles != nil {
+		profilesOut := os.Stdout
+		if output == jsonOutput {
+			profilesOut = os.Stderr
+		}
+		if err := profiles.WriteJSON(profilesOut); err != nil {
+			return errors.Wrap(err, "write build profiles")
+		}
+	}
+
+	if noManifest {
+		return nil
+	}
+	if helmValues != nil {
+		return updateHelmValues(helmValues, results)
+	}
+	images := lo.Map(results, func(r *ServiceResult, _ int) *Image {
+		return r.Image
+	})
+	if kustomization != "" {
+		if err := updateKustomizeImages(kustomization, images); err != nil {
+			return err
+		}
+		// the namespace manifests are only kept up to date along with --legacy-images-format
+		if !legacyImagesFormat {
+			return nil
+		}
+	}
+
+	if manifestNamespace != "" {
+		manifestNamespaces = append([]string{manifestNamespace}, manifestNamespaces...)
+	}
+	if len(manifestNamespaces) == 0 {
+		return nil
+	}
+	if err := updateK8sDeployments(manifestNamespaces, images, manifestYq, hints); err != nil {
+		return err
+	}
+
+	if verifyManifest {
+		for _, ns := range lo.Uniq(manifestNamespaces) {
+			if err := verifyManifestImages(ctx, kustomizationPath(ns), settings.remoteOptions...); err != nil {
+				return errors.Wrap(err, "verify manifest")
+			}
+		}
+	}
+	return nil
+}
+
+// checkTagsUnused fails when any tag about to be pushed already exists, instead of getting
+// an opaque error from ECR while publishing to a repository with immutable tags.
+func checkTagsUnused(ctx context.Context, ecr *registry.ECR, services []GoServiceConfig, namespace string) error {
+	existing := []string{}
+	for _, service := range services {
+		repo, err := service.RepoName(namespace)
+		if err != nil {
+			return err
+		}
+		for _, tag := range service.GetTags() {
+			exists, err := ecr.TagExists(ctx, repo, tag)
+			if err != nil {
+				return errors.Wrapf(err, "check tag %s:%s", repo, tag)
+			}
+			if exists {
+				existing = append(existing, repo+":"+tag)
+			}
+		}
+	}
+
+	if len(existing) > 0 {
+		return errors.Errorf("tags already exist and are immutable: %s", strings.Join(existing, ", "))
+	}
+	return nil
+}
+
+// shuffleServices randomizes the build order, a diagnostic aid for measuring how much
+// the configured order helps build caching. The same seed always gives the same order.
+func shuffleServices(services []GoServiceConfig, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	rnd.Shuffle(len(services), func(i, j int) {
+		services[i], services[j] = services[j], services[i]
+	})
+}
+
+// isDefaultBranch reports whether the release runs on the configured default branch,
+// taking the branch from the --branch flag or falling back to the current git branch.
+func isDefaultBranch(ctx context.Context, cmd *cobra.Command) (bool, error) {
+	branch, err := cmd.Flags().GetString("branch")
+	if err != nil {
+		return false, errors.Wrap(err, "failed getting branch flag")
+	}
+
+	if branch == "" {
+		branch, err = currentGitBranch(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return branch == viper.GetString("default_branch"), nil
+}
+
+func createMissingReposCommand(ctx context.Context, cmd *cobra.Command, _ []string, registryName string) error {
+	configPaths, err := cmd.Flags().GetStringArray("config")
+	if err != nil {
+		return errors.Wrap(err, "failed getting config flag")
+	}
+	config, err := getConfig(registryName, configPaths)
+	if err != nil {
+		return errors.Wrap(err, "get services config")
+	}
+	if err := config.Validate(registryName); err != nil {
+		return err
+	}
+
+	namespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return errors.Wrap(err, "failed getting namespace flag")
+	}
+
+	immutableTags, err := cmd.Flags().GetBool("immutable-tags")
+	if err != nil {
+		return errors.Wrap(err, "failed getting immutable-tags flag")
+	}
+	if immutableTags && config.ECR != nil {
+		config.ECR.SetImmutableTags(true)
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return errors.Wrap(err, "failed getting dry-run flag")
+	}
+
+	reconcilePolicy, err := cmd.Flags().GetBool("reconcile-policy")
+	if err != nil {
+		return errors.Wrap(err, "failed getting reconcile-policy flag")
+	}
+	if reconcilePolicy && (config.ECR == nil || config.ECR.RepositoryPolicy() == "") {
+		return errors.Errorf("--reconcile-policy requires %s.repository_policy in config", registrySection(registryName))
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return errors.Wrap(err, "failed getting output flag")
+	}
+
+	maxGoRoutines, err := cmd.Flags().GetInt("max-go-routines")
+	if err != nil {
+		return errors.Wrap(err, "failed getting max-go-routines flag")
+	}
+	if maxGoRoutines < 1 {
+		return errors.Errorf("max-go-routines must be at least 1, got %d", maxGoRoutines)
+	}
+
+	repoRegistry, ok := config.Registry.(CreateRepoRegistry)
+	if !ok {
+		return errors.Errorf("%s registry does not support creating repositories", registryName)
+	}
+
+	provisions := &repoProvisions{DryRun: dryRun}
+	repoNames := map[string]string{}
+	for _, service := range config.ServicesConfig.GoServices {
+		repo, err := service.RepoName(namespace)
+		if err != nil {
+			return err
+		}
+		repoNames[service.Name] = repo
+
+		repos := []string{repo}
+		if service.Chart != "" {
+			metadata, err := readChartMetadata(service.Chart)
+			if err != nil {
+				return errors.Wrapf(err, "chart of %s", service.Name)
+			}
+			chartRepo, err := chartRepoName(service, namespace, metadata.Name)
+			if err != nil {
+				return err
+			}
+			repos = append(repos, chartRepo)
+		}
+
+		for _, repo := range repos {
+			provisions.Repositories = append(provisions.Repositories, &repoProvision{Repository: repo, Status: repoExisted})
+		}
+	}
+
+	provisions.Provision(ctx, repoRegistry, config.ECR, reconcilePolicy, maxGoRoutines)
+
+	provisions.Print()
+	if output != "" {
+		if err := provisions.WriteJSON(output); err != nil {
+			return errors.Wrap(err, "write output")
+		}
+	}
+	if failed := provisions.Failed(); len(failed) > 0 {
+		return errors.Errorf("failed provisioning %d repositories", len(failed))
+	}
+
+	scaffold, err := cmd.Flags().GetBool("scaffold")
+	if err != nil {
+		return errors.Wrap(err, "failed getting scaffold flag")
+	}
+	if !scaffold || dryRun {
+		return nil
+	}
+	if namespace == "" {
+		return errors.New("--scaffold requires --namespace")
+	}
+
+	// until a release writes the digests, point each service at its bare repository
+	images := lo.Map(config.ServicesConfig.GoServices, func(service GoServiceConfig, _ int) *Image {
+		return &Image{
+			OldName: service.OldImageName(),
+			NewName: fmt.Sprintf("%s/%s", config.Registry.URL(), strings.ToLower(repoNames[service.Name])),
+		}
+	})
+	return scaffoldKustomization(namespace, images)
 }
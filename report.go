@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	reportReleased   = "released"
+	reportFailed     = "failed"
+	reportSkipped    = "skipped"
+	reportNotStarted = "not_started"
+)
+
+type serviceReport struct {
+	Service  string        `json:"service"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	// Reason tells why a service was skipped or not started
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalJSON exposes the duration in milliseconds like the rest of ippon's JSON output.
+func (this serviceReport) MarshalJSON() ([]byte, error) {
+	type alias serviceReport
+	return json.Marshal(struct {
+		alias
+		Passed     bool  `json:"passed"`
+		DurationMs int64 `json:"duration_ms"`
+	}{alias(this), this.Status == reportReleased || this.Status == reportSkipped, this.Duration.Milliseconds()})
+}
+
+// releaseReport collects per-service outcomes for CI, written as JUnit XML
+// (or JSON when the report path ends in .json) with one test case per service, sorted by
+// name whatever order the services finished in.
+type releaseReport struct {
+	mu       sync.Mutex
+	services []serviceReport
+}
+
+func (this *releaseReport) Add(service string, duration time.Duration, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	entry := serviceReport{Service: service, Status: reportReleased, Duration: duration}
+	if err != nil {
+		entry.Status = reportFailed
+		entry.Error = err.Error()
+	}
+	this.services = append(this.services, entry)
+}
+
+// Skip reports a service left out of the release because it needed no build, such as one
+// already published in the checkpoint.
+func (this *releaseReport) Skip(service, reason string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.services = append(this.services, serviceReport{Service: service, Status: reportSkipped, Reason: reason})
+}
+
+// NotStarted reports the services of the release that have no outcome, as the release
+// stopped before building them.
+func (this *releaseReport) NotStarted(services []string, reason string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for _, service := range services {
+		if lo.ContainsBy(this.services, func(s serviceReport) bool { return s.Service == service }) {
+			continue
+		}
+		this.services = append(this.services, serviceReport{Service: service, Status: reportNotStarted, Reason: reason})
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+func (this *releaseReport) Write(path string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	sort.SliceStable(this.services, func(i, j int) bool {
+		return this.services[i].Service < this.services[j].Service
+	})
+
+	var out []byte
+	var err error
+	if filepath.Ext(path) == ".json" {
+		out, err = json.MarshalIndent(this.services, "", "  ")
+	} else {
+		out, err = this.junit()
+	}
+	if err != nil {
+		return errors.Wrap(err, "marshal report")
+	}
+
+	return os.WriteFile(path, out, 0644)
+}
+
+func (this *releaseReport) junit() ([]byte, error) {
+	suite := junitSuite{
+		Name:  "ippon",
+		Tests: len(this.services),
+	}
+
+	for _, service := range this.services {
+		testCase := junitTestCase{
+			Name:      service.Service,
+			ClassName: "ippon",
+			Time:      service.Duration.Seconds(),
+		}
+		switch service.Status {
+		case reportFailed:
+			suite.Failures++
+			testCase.Failure = &junitFailure{Message: "release failed", Text: service.Error}
+		case reportSkipped:
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{Message: service.Reason}
+		case reportNotStarted:
+			// the failure that stopped the release is already reported by its own service
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{Message: "not started: " + service.Reason}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = lo.SumBy(suite.Cases, func(c junitTestCase) float64 { return c.Time })
+
+	out, err := xml.MarshalIndent(junitTestSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestReleaseReport(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{
+			name: "json",
+			file: "report.json",
+			want: `[
+  {
+    "service": "api",
+    "status": "released",
+    "passed": true,
+    "duration_ms": 1000
+  },
+  {
+    "service": "cron",
+    "status": "skipped",
+    "reason": "already published in checkpoint",
+    "passed": true,
+    "duration_ms": 0
+  },
+  {
+    "service": "db",
+    "status": "failed",
+    "error": "push denied",
+    "passed": false,
+    "duration_ms": 2000
+  },
+  {
+    "service": "queue",
+    "status": "not_started",
+    "reason": "another service failed",
+    "passed": false,
+    "duration_ms": 0
+  },
+  {
+    "service": "web",
+    "status": "released",
+    "passed": true,
+    "duration_ms": 3000
+  }
+]`,
+		},
+		{
+			name: "junit",
+			file: "report.xml",
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="ippon" tests="5" failures="1" skipped="2" time="6">
+    <testcase name="api" classname="ippon" time="1"></testcase>
+    <testcase name="cron" classname="ippon" time="0">
+      <skipped message="already published in checkpoint"></skipped>
+    </testcase>
+    <testcase name="db" classname="ippon" time="2">
+      <failure message="release failed">push denied</failure>
+    </testcase>
+    <testcase name="queue" classname="ippon" time="0">
+      <skipped message="not started: another service failed"></skipped>
+    </testcase>
+    <testcase name="web" classname="ippon" time="3"></testcase>
+  </testsuite>
+</testsuites>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// services are added in the order they finish
+			report := &releaseReport{}
+			report.Add("web", 3*time.Second, nil)
+			report.Skip("cron", "already published in checkpoint")
+			report.Add("db", 2*time.Second, errors.New("push denied"))
+			report.Add("api", time.Second, nil)
+			report.NotStarted([]string{"api", "cron", "db", "queue", "web"}, "another service failed")
+
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := report.Write(path); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("report:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+const buildRetryBackoff = 2 * time.Second
+
+// transientBuildErrors are found in go command output when fetching modules failed
+// because of the network or the module proxy rather than the code being built.
+var transientBuildErrors = []string{
+	"dial tcp",
+	"i/o timeout",
+	"connection reset by peer",
+	"connection refused",
+	"TLS handshake timeout",
+	"no such host",
+	"unexpected EOF",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+func isTransientBuildError(err error) bool {
+	msg := err.Error()
+	for _, transient := range transientBuildErrors {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientPublishError tells registry throttling, server errors and network failures,
+// worth pushing again, from auth and manifest errors which would fail again.
+func isTransientPublishError(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.Temporary() || terr.StatusCode == http.StatusTooManyRequests
+	}
+	return isTransientBuildError(err)
+}
+
+// withRetries calls fn until it succeeds, fails with an error retryable rejects or
+// was retried retries times, doubling the wait between attempts and adding up to half
+// of it at random so concurrent retries don't hit the registry at once.
+func withRetries(ctx context.Context, what string, retries int, backoff time.Duration, retryable func(error) bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !retryable(err) {
+			return err
+		}
+
+		wait := backoff << attempt
+		if wait > 1 {
+			wait += time.Duration(rand.Int63n(int64(wait / 2)))
+		}
+		log.Printf("ippon retrying %s (%d/%d) in %s: %v\n", what, attempt+1, retries, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	ocimutate "github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	"github.com/sigstore/cosign/v2/pkg/oci/signed"
+)
+
+// resultSBOM returns the SBOM ko generated for a build result, if any.
+func resultSBOM(r build.Result) oci.File {
+	se, ok := r.(oci.SignedEntity)
+	if !ok {
+		return nil
+	}
+
+	f, err := se.Attachment("sbom")
+	if err != nil {
+		// no SBOM was generated for this result
+		return nil
+	}
+	return f
+}
+
+// withSBOM attaches sbom back to a build result once ippon is done mutating it, for the
+// publisher to push it next to the image. Only the top level SBOM is kept, the one of
+// the index for multi-platform images.
+func withSBOM(r build.Result, sbom oci.File) (build.Result, error) {
+	switch v := r.(type) {
+	case v1.ImageIndex:
+		return ocimutate.AttachFileToImageIndex(signed.ImageIndex(v), "sbom", sbom)
+	case v1.Image:
+		return ocimutate.AttachFileToImage(signed.Image(v), "sbom", sbom)
+	}
+	return nil, errors.Errorf("unexpected build result %T", r)
+}
+
+// withoutAttachments hides the SBOM and signatures of a build result from the publisher,
+// which would otherwise push them next to the image.
+func withoutAttachments(r build.Result) build.Result {
+	switch v := r.(type) {
+	case oci.SignedImageIndex:
+		return struct{ v1.ImageIndex }{v}
+	case oci.SignedImage:
+		return struct{ v1.Image }{v}
+	}
+	return r
+}
+
+// writeSBOMFile writes an SPDX SBOM to dir/<service>.spdx.json with a document annotation
+// recording the digest of the published image, which differs from the digest ko describes
+// when ippon mutated the image after building it.
+func writeSBOMFile(dir, serviceName string, sbom []byte, digest string) error {
+	var doc map[string]any
+	err := json.Unmarshal(sbom, &doc)
+	if err != nil {
+		return errors.Wrap(err, "unmarshal SBOM")
+	}
+
+	annotations, _ := doc["annotations"].([]any)
+	doc["annotations"] = append(annotations, map[string]any{
+		"annotationDate": time.Now().UTC().Format(time.RFC3339),
+		"annotationType": "OTHER",
+		"annotator":      "Tool: ippon",
+		"comment":        "published image digest " + digest,
+	})
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal SBOM")
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, serviceName+".spdx.json"), out, 0644)
+}
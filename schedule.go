@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// releaseOrder returns the warmup service and the services building after it. Serially,
+// services build in plain config order, dependencies first, the warmup service among them.
+func releaseOrder(services []GoServiceConfig, warmup string, strict, serial bool) (*GoServiceConfig, []GoServiceConfig, error) {
+	if serial {
+		if warmup != "" {
+			log.Printf("ippon building serially in config order, without warming up with %s\n", warmup)
+		}
+		return nil, services, nil
+	}
+	return splitWarmupService(services, warmup, strict)
+}
+
+// runServices releases services through run. The warmup service builds alone first so the
+// others start with a warm Go build cache. Then each wave of groupWaves builds once the
+// previous one is released, up to maxGoRoutines services at a time in order, so with 1
+// the next service only starts once the previous one is published. Failing fast, the
+// first failure calls cancel for the services in flight and skips the ones not started
+// yet, otherwise every service is released.
+func runServices(warmup *GoServiceConfig, waves [][]GoServiceConfig, maxGoRoutines int, failFast bool, cancel func(), run func(GoServiceConfig) error) {
+	var stopped atomic.Bool
+	release := func(service GoServiceConfig) {
+		if stopped.Load() {
+			return
+		}
+		if run(service) != nil && failFast {
+			stopped.Store(true)
+			cancel()
+		}
+	}
+
+	if warmup != nil {
+		log.Printf("ippon warming up the build cache with %s\n", warmup.Name)
+		release(*warmup)
+	}
+
+	for _, wave := range waves {
+		var g errgroup.Group
+		g.SetLimit(maxGoRoutines)
+		for _, service := range wave {
+			service := service
+			g.Go(func() error {
+				release(service)
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}
+}
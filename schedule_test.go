@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSerialReleaseOrder(t *testing.T) {
+	services := []GoServiceConfig{{Name: "api"}, {Name: "worker"}, {Name: "web"}}
+	tests := []struct {
+		name     string
+		warmup   string
+		failing  string
+		failFast bool
+		want     []string
+	}{
+		{
+			name: "config order",
+			want: []string{"building api", "released api", "building worker", "released worker", "building web", "released web"},
+		},
+		{
+			name:   "warmup service left in place",
+			warmup: "web",
+			want:   []string{"building api", "released api", "building worker", "released worker", "building web", "released web"},
+		},
+		{
+			name:     "fail fast",
+			failing:  "worker",
+			failFast: true,
+			want:     []string{"building api", "released api", "building worker", "failed worker"},
+		},
+		{
+			name:    "keep going",
+			failing: "worker",
+			want:    []string{"building api", "released api", "building worker", "failed worker", "building web", "released web"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warmup, rest, err := releaseOrder(services, test.warmup, true, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			logger := log.New(&out, "", 0)
+			// earlier services take longer, concurrent builds would log out of order
+			delays := map[string]time.Duration{"api": 30 * time.Millisecond, "worker": 20 * time.Millisecond, "web": 10 * time.Millisecond}
+			run := func(service GoServiceConfig) error {
+				logger.Printf("building %s", service.Name)
+				time.Sleep(delays[service.Name])
+				if service.Name == test.failing {
+					logger.Printf("failed %s", service.Name)
+					return errors.New("build failed")
+				}
+				logger.Printf("released %s", service.Name)
+				return nil
+			}
+
+			waves, err := groupWaves(rest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runServices(warmup, waves, 1, test.failFast, func() {}, run)
+
+			got := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if !slices.Equal(got, test.want) {
+				t.Errorf("log\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestWarmupReleasesAlone(t *testing.T) {
+	services := []GoServiceConfig{{Name: "api"}, {Name: "worker"}, {Name: "web"}}
+	warmup, rest, err := releaseOrder(services, warmupFirstService, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waves, err := groupWaves(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	runServices(warmup, waves, 2, true, func() {}, func(service GoServiceConfig) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, service.Name)
+		return nil
+	})
+
+	if len(order) != 3 || order[0] != "api" {
+		t.Errorf("release order %v, want api first", order)
+	}
+}
+
+func TestReleaseFollowsDependencies(t *testing.T) {
+	services := []GoServiceConfig{
+		{Name: "web", DependsOn: []string{"api"}},
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "worker"},
+		{Name: "db"},
+	}
+	waves, err := groupWaves(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	released := map[string]bool{}
+	runServices(nil, waves, 4, true, func() {}, func(service GoServiceConfig) error {
+		mu.Lock()
+		for _, dep := range service.DependsOn {
+			if !released[dep] {
+				t.Errorf("%s started before its dependency %s was released", service.Name, dep)
+			}
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		released[service.Name] = true
+		mu.Unlock()
+		return nil
+	})
+
+	if len(released) != len(services) {
+		t.Errorf("released %v, want every service", released)
+	}
+}
+
+func TestFailFastSkipsLaterWaves(t *testing.T) {
+	waves, err := groupWaves([]GoServiceConfig{
+		{Name: "db"},
+		{Name: "api", DependsOn: []string{"db"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := false
+	var started []string
+	runServices(nil, waves, 4, true, func() { cancelled = true }, func(service GoServiceConfig) error {
+		started = append(started, service.Name)
+		return errors.New("build failed")
+	})
+
+	if !cancelled || !slices.Equal(started, []string{"db"}) {
+		t.Errorf("started %v, cancelled %t, want db only and a cancelled release", started, cancelled)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// readServiceFiles reads the per-service config files matching the service_files globs,
+// each holding a single go_services entry. A relative main is relative to the file's
+// directory, which is also the default main.
+func readServiceFiles(patterns []string) ([]GoServiceConfig, error) {
+	paths := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid service_files pattern %q", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	paths = lo.Uniq(paths)
+	sort.Strings(paths)
+
+	services := make([]GoServiceConfig, 0, len(paths))
+	for _, path := range paths {
+		service, err := readServiceFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "service file %s", path)
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func readServiceFile(path string) (GoServiceConfig, error) {
+	var service GoServiceConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return service, err
+	}
+
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return service, err
+	}
+	raw, err = expandRaw(raw)
+	if err != nil {
+		return service, err
+	}
+
+	err = decodeConfig(raw, &service)
+	if err != nil {
+		return service, err
+	}
+
+	if service.Name == "" {
+		return service, errors.New("missing name")
+	}
+
+	dir := filepath.Dir(path)
+	if service.Main == "" {
+		service.Main = dir
+	} else if !filepath.IsAbs(service.Main) {
+		service.Main = filepath.Join(dir, service.Main)
+	}
+
+	return service, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const defaultLogDir = ".ippon/logs"
+
+// serviceLogger returns the logger of a service's release, which also writes to
+// <dir>/<service>.log when dir is set. ko's own output goes to the global log only.
+func serviceLogger(dir, service string) (*log.Logger, func(), error) {
+	if dir == "" {
+		return log.Default(), func() {}, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create log dir")
+	}
+
+	f, err := os.Create(filepath.Join(dir, service+".log"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create service log")
+	}
+
+	logger := log.New(io.MultiWriter(log.Writer(), f), log.Prefix(), log.Flags())
+	return logger, func() { f.Close() }, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+)
+
+// knownConfigKeys lists every top level key ippon reads, besides the registry sections.
+var knownConfigKeys = []string{
+	"go_services",
+	"tags",
+	"base_image",
+	"platform_base_images",
+	"default_branch",
+	"keychains",
+	"builder",
+	"publisher",
+	"plugins",
+	"excluded_services",
+	"exclude",
+	"go_version",
+	"annotations",
+	"index_annotations",
+	"manifest_annotations",
+	"registry_headers",
+	"debug_shell",
+	"lax_config",
+	"vars",
+	"registry_tags",
+	"cache_warmup_service",
+	"warmup_strict",
+	"base_images",
+	"env",
+	"max_base_age",
+	"repo_template",
+	"service_files",
+	"digest_tag",
+	"freshness_labels",
+	"bom_repository",
+	"old_image_prefix",
+	"platforms",
+	"ldflags",
+	"sbom",
+	"images_output_dir",
+	"helm_values",
+}
+
+// checkConfigKeys fails on top level keys ippon doesn't know about, which viper would
+// otherwise silently ignore, and decodes go_services rejecting unknown service keys.
+func checkConfigKeys(registrySections []string) error {
+	known := append(append([]string{}, knownConfigKeys...), registrySections...)
+
+	unknown := lo.Filter(lo.Keys(viper.AllSettings()), func(key string, _ int) bool {
+		return !lo.Contains(known, key)
+	})
+	sort.Strings(unknown)
+	if len(unknown) > 0 {
+		msgs := lo.Map(unknown, func(key string, _ int) string {
+			if suggestion := closestKey(key, known); suggestion != "" {
+				return key + " (did you mean " + suggestion + "?)"
+			}
+			return key
+		})
+		return errors.Errorf("unknown config keys: %s", strings.Join(msgs, ", "))
+	}
+
+	// decoded the way viper decodes them, so only unknown keys fail on top of it
+	var services []GoServiceConfig
+	err := viper.UnmarshalKey("go_services", &services, func(c *mapstructure.DecoderConfig) {
+		c.ErrorUnused = true
+	})
+	return errors.Wrap(err, "invalid go_services")
+}
+
+// closestKey returns the known key within a couple of edits of key, if any.
+func closestKey(key string, known []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range known {
+		if d := editDistance(key, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
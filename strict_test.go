@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckConfigKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "values viper accepts",
+			config: "go_services:\n- name: api\n  tags: v1\n  platforms: linux/amd64,linux/arm64\n",
+		},
+		{
+			name:    "unknown service key",
+			config:  "go_services:\n- name: api\n  tag: v1\n",
+			wantErr: "tag",
+		},
+		{
+			name:    "unknown top level key",
+			config:  "go_service:\n- name: api\n",
+			wantErr: "go_service (did you mean go_services?)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := viper.ReadConfig(strings.NewReader(test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkConfigKeys(registrySections)
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadServicesFileDecodesLikeViper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	config := "go_services:\n- name: api\n  tags: v1\n  platforms: linux/amd64,linux/arm64\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := readServicesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("%d services, want 1", len(services))
+	}
+	if !slices.Equal(services[0].Tags, []string{"v1"}) {
+		t.Errorf("tags %v, want [v1]", services[0].Tags)
+	}
+	if !slices.Equal(services[0].Platforms, []string{"linux/amd64", "linux/arm64"}) {
+		t.Errorf("platforms %v, want [linux/amd64 linux/arm64]", services[0].Platforms)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+const jsonOutput = "json"
+
+// serviceSummary is the entry of a released service in the --output json summary.
+type serviceSummary struct {
+	Service      string        `json:"service"`
+	OldImage     string        `json:"old_image"`
+	NewImage     string        `json:"new_image"`
+	Digest       string        `json:"digest"`
+	Tags         []string      `json:"tags"`
+	Channels     []string      `json:"channels,omitempty"`
+	Repositories []string      `json:"repositories,omitempty"`
+	TagResults   []TagResult   `json:"tag_results,omitempty"`
+	Charts       []string      `json:"charts,omitempty"`
+	Attachments  []*Attachment `json:"attachments,omitempty"`
+	GoVersion    string        `json:"go_version,omitempty"`
+	// Profile is only set with --profile-builds
+	Profile *BuildProfile `json:"profile,omitempty"`
+	// DurationMs is 0 for services skipped because unchanged or already checkpointed
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// releaseSummary is the --output json summary of a release.
+type releaseSummary struct {
+	Services []serviceSummary `json:"services"`
+	// BOM is the reference of the release bill of materials, set when bom_repository is
+	BOM string `json:"bom,omitempty"`
+}
+
+// writeReleaseSummary writes the results sorted by service and the bill of materials
+// reference as JSON, once every service is released so concurrent builds can't interleave with it.
+func writeReleaseSummary(w io.Writer, results []*ServiceResult, bomRef string) error {
+	services := make([]serviceSummary, 0, len(results))
+	for _, r := range results {
+		services = append(services, serviceSummary{
+			Service:      r.Service,
+			OldImage:     r.Image.OldName,
+			NewImage:     r.Image.NewName,
+			Digest:       r.Digest,
+			Tags:         r.Tags,
+			Channels:     r.Channels,
+			Repositories: r.Repositories,
+			TagResults:   r.TagResults,
+			Charts:       r.Charts,
+			Attachments:  r.Attachments,
+			GoVersion:    r.GoVersion,
+			Profile:      r.Profile,
+			DurationMs:   r.Duration.Milliseconds(),
+		})
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Service < services[j].Service
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(releaseSummary{Services: services, BOM: bomRef})
+}
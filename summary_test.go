@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+func TestWriteReleaseSummarySortsServices(t *testing.T) {
+	// results arrive in the order builds finish
+	results := []*ServiceResult{
+		{Service: "web", Image: &Image{OldName: "registry.lema.ai/web", NewName: "x.io/web@sha256:33"}, Duration: 3 * time.Second},
+		{Service: "api", Image: &Image{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"}, Duration: time.Second},
+		{Service: "db", Image: &Image{OldName: "registry.lema.ai/db", NewName: "x.io/db@sha256:22"}},
+	}
+
+	var out bytes.Buffer
+	if err := writeReleaseSummary(&out, results, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var release releaseSummary
+	if err := json.Unmarshal(out.Bytes(), &release); err != nil {
+		t.Fatal(err)
+	}
+	summary := release.Services
+	got := lo.Map(summary, func(s serviceSummary, _ int) string { return s.Service })
+	want := []string{"api", "db", "web"}
+	if !slices.Equal(got, want) {
+		t.Errorf("services %v, want %v", got, want)
+	}
+	if summary[0].NewImage != "x.io/api@sha256:11" || summary[0].DurationMs != 1000 {
+		t.Errorf("api entry %+v", summary[0])
+	}
+}
+
+func TestWriteReleaseSummaryServiceDetails(t *testing.T) {
+	results := []*ServiceResult{
+		{
+			Service:     "api",
+			Image:       &Image{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"},
+			Attachments: []*Attachment{{Type: "sbom", Path: "api.spdx.json", Digest: "sha256:aa"}},
+			GoVersion:   "go1.22.7",
+			Profile:     &BuildProfile{Service: "api", WallTimeMs: 1200, UserCPUMs: 900},
+		},
+		{Service: "web", Image: &Image{OldName: "registry.lema.ai/web", NewName: "x.io/web@sha256:22"}},
+	}
+
+	var out bytes.Buffer
+	if err := writeReleaseSummary(&out, results, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var release struct {
+		Services []map[string]json.RawMessage `json:"services"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &release); err != nil {
+		t.Fatal(err)
+	}
+	summary := release.Services
+
+	tests := []struct {
+		name string
+		idx  int
+		key  string
+		want string
+	}{
+		{name: "attachments", idx: 0, key: "attachments", want: `[{"type":"sbom","path":"api.spdx.json","digest":"sha256:aa"}]`},
+		{name: "go version", idx: 0, key: "go_version", want: `"go1.22.7"`},
+		{name: "profile", idx: 0, key: "profile", want: `{"service":"api","wall_time_ms":1200,"user_cpu_ms":900,"system_cpu_ms":0,"release_child_max_rss_kb":0,"release_heap_alloc_bytes":0}`},
+		{name: "no attachments", idx: 1, key: "attachments"},
+		{name: "no go version", idx: 1, key: "go_version"},
+		{name: "no profile", idx: 1, key: "profile"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, ok := summary[test.idx][test.key]
+			if test.want == "" {
+				if ok {
+					t.Errorf("%s set to %s, want it left out", test.key, raw)
+				}
+				return
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, raw); err != nil {
+				t.Fatal(err)
+			}
+			if compact.String() != test.want {
+				t.Errorf("%s %s, want %s", test.key, compact.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestWriteReleaseSummaryBOM(t *testing.T) {
+	results := []*ServiceResult{
+		{Service: "api", Image: &Image{OldName: "registry.lema.ai/api", NewName: "x.io/api@sha256:11"}},
+	}
+
+	tests := []struct {
+		name   string
+		bomRef string
+		want   bool
+	}{
+		{name: "pushed", bomRef: "x.io/bom@sha256:bb", want: true},
+		{name: "no bom_repository", bomRef: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeReleaseSummary(&out, results, test.bomRef); err != nil {
+				t.Fatal(err)
+			}
+
+			var release map[string]json.RawMessage
+			if err := json.Unmarshal(out.Bytes(), &release); err != nil {
+				t.Fatal(err)
+			}
+			raw, ok := release["bom"]
+			if ok != test.want {
+				t.Fatalf("bom set %t, want %t: %s", ok, test.want, out.String())
+			}
+			if ok && string(raw) != `"`+test.bomRef+`"` {
+				t.Errorf("bom %s, want %s", raw, test.bomRef)
+			}
+		})
+	}
+}
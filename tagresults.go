@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+// TagResult tells whether a tag landed on the released image.
+type TagResult struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (this TagResult) Failed() bool {
+	return this.Error != ""
+}
+
+// pushedTagResults records every tag of every repository as pushed.
+func pushedTagResults(repositoryTags map[string][]string) []TagResult {
+	results := []TagResult{}
+	for repository, tags := range repositoryTags {
+		for _, tag := range tags {
+			results = append(results, TagResult{Repository: repository, Tag: tag})
+		}
+	}
+	sortTagResults(results)
+	return results
+}
+
+// checkTagResults finds out which tags point at digest after a publish failed part way,
+// publishers only reporting the first failure.
+func checkTagResults(ctx context.Context, repositoryTags map[string][]string, digest v1.Hash, options ...remote.Option) []TagResult {
+	options = append([]remote.Option{remote.WithContext(ctx)}, options...)
+	results := []TagResult{}
+	for repository, tags := range repositoryTags {
+		for _, tag := range tags {
+			result := TagResult{Repository: repository, Tag: tag}
+			ref, err := name.NewTag(repository + ":" + tag)
+			if err == nil {
+				var desc *v1.Descriptor
+				desc, err = remote.Head(ref, options...)
+				if err == nil && desc.Digest != digest {
+					err = errors.Errorf("points at %s", desc.Digest)
+				}
+			}
+			if err != nil {
+				result.Error = err.Error()
+				log.Printf("ippon tag %s:%s was not pushed: %v\n", repository, tag, err)
+			}
+			results = append(results, result)
+		}
+	}
+	sortTagResults(results)
+	return results
+}
+
+func sortTagResults(results []TagResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repository != results[j].Repository {
+			return results[i].Repository < results[j].Repository
+		}
+		return results[i].Tag < results[j].Tag
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// useGoToolchain pins the go command ko runs to version through GOTOOLCHAIN, letting the
+// go command download it when it isn't installed. It returns the version the go command
+// reports, which is the ambient toolchain when version is empty.
+func useGoToolchain(ctx context.Context, version string) (string, error) {
+	if version != "" {
+		if !strings.HasPrefix(version, "go") {
+			version = "go" + version
+		}
+		if err := os.Setenv("GOTOOLCHAIN", version); err != nil {
+			return "", errors.Wrap(err, "set GOTOOLCHAIN")
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "go", "env", "GOVERSION").Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "go toolchain %s is not available", version)
+	}
+
+	effective := strings.TrimSpace(string(out))
+	if version != "" && effective != version {
+		return "", errors.Errorf("requested go toolchain %s but go reports %s", version, effective)
+	}
+	return effective, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/samber/lo"
+)
+
+// writeImageURLs writes the fully qualified reference of every pushed image,
+// one per line or as a JSON object of service to reference.
+func writeImageURLs(w io.Writer, results []*ServiceResult, asJSON bool) error {
+	if asJSON {
+		urls := lo.SliceToMap(results, func(r *ServiceResult) (string, string) {
+			return r.Service, r.Image.NewName
+		})
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(urls)
+	}
+
+	for _, result := range results {
+		if _, err := fmt.Fprintln(w, result.Image.NewName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeImageURLsFile(path string, results []*ServiceResult) error {
+	return writeImageURLsToFile(path, results, filepath.Ext(path) == ".json")
+}
+
+func writeImageURLsJSONFile(path string, results []*ServiceResult) error {
+	return writeImageURLsToFile(path, results, true)
+}
+
+func writeImageURLsToFile(path string, results []*ServiceResult, asJSON bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeImageURLs(f, results, asJSON)
+}
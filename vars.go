@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+)
+
+// lookupVar resolves a $NAME or ${NAME} reference, first in the config's vars map,
+// case-insensitively since viper lowercases map keys, then in the environment. Vars
+// values may themselves reference environment variables, which are expanded before use,
+// but not other vars. ${NAME:-default} falls back to default when NAME is unset.
+func lookupVar(ref string) (string, bool) {
+	name, fallback, hasFallback := strings.Cut(ref, ":-")
+
+	if value, ok := viper.GetStringMapString("vars")[strings.ToLower(name)]; ok {
+		return os.ExpandEnv(value), true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	return fallback, hasFallback
+}
+
+// opaqueConfigKeys hold documents with a ${...} syntax of their own, such as the IAM
+// policy variables of ECR repository policies, and are never expanded.
+var opaqueConfigKeys = []string{"repository_policy"}
+
+// expandConfig substitutes $NAME, ${NAME} and ${NAME:-default} references in every
+// config value but the vars map and opaqueConfigKeys, failing on references to unset
+// names without a default. $$ stands for a literal $. It runs when the config is read, so
+// placeholders such as BASE_URL are replaced afterwards, on the expanded value.
+func expandConfig() error {
+	missing := map[string][]string{}
+	for key, value := range viper.AllSettings() {
+		if key == "vars" {
+			continue
+		}
+		expanded := expandValue(key, value, missing)
+		if !reflect.DeepEqual(expanded, value) {
+			viper.Set(key, expanded)
+		}
+	}
+	return missingVarsError(missing)
+}
+
+// expandRaw expands the references in a config document read outside of viper, such as
+// merged config files and service files.
+func expandRaw(raw map[string]interface{}) (map[string]interface{}, error) {
+	missing := map[string][]string{}
+	expanded := lo.MapValues(raw, func(value interface{}, key string) interface{} {
+		return expandValue(key, value, missing)
+	})
+	return expanded, missingVarsError(missing)
+}
+
+// expandValue expands the strings of value, recording the unset names referenced under
+// key in missing.
+func expandValue(key string, value interface{}, missing map[string][]string) interface{} {
+	if lo.Contains(opaqueConfigKeys, key[strings.LastIndex(key, ".")+1:]) {
+		return value
+	}
+
+	switch value := value.(type) {
+	case string:
+		if !strings.Contains(value, "$") {
+			return value
+		}
+		return os.Expand(value, func(ref string) string {
+			// os.Expand reads $$ as a reference to $
+			if ref == "$" {
+				return "$"
+			}
+			expanded, ok := lookupVar(ref)
+			if !ok {
+				name, _, _ := strings.Cut(ref, ":-")
+				missing[name] = append(missing[name], key)
+			}
+			return expanded
+		})
+	case []string:
+		return lo.Map(value, func(item string, i int) string {
+			return expandValue(fmt.Sprintf("%s[%d]", key, i), item, missing).(string)
+		})
+	case []interface{}:
+		return lo.Map(value, func(item interface{}, i int) interface{} {
+			return expandValue(fmt.Sprintf("%s[%d]", key, i), item, missing)
+		})
+	case map[string]interface{}:
+		return lo.MapValues(value, func(item interface{}, k string) interface{} {
+			return expandValue(key+"."+k, item, missing)
+		})
+	case map[interface{}]interface{}:
+		return lo.MapValues(value, func(item interface{}, k interface{}) interface{} {
+			return expandValue(fmt.Sprintf("%s.%v", key, k), item, missing)
+		})
+	default:
+		return value
+	}
+}
+
+func missingVarsError(missing map[string][]string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	names := lo.Keys(missing)
+	sort.Strings(names)
+	msgs := lo.Map(names, func(name string, _ int) string {
+		keys := missing[name]
+		sort.Strings(keys)
+		return fmt.Sprintf("%s (in %s)", name, strings.Join(lo.Uniq(keys), ", "))
+	})
+	return errors.Errorf("unset variables, set them, add them to vars, use ${NAME:-default} or $$ for a literal $: %s", strings.Join(msgs, ", "))
+}
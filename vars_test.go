@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandRaw(t *testing.T) {
+	t.Setenv("IPPON_TEST_REGISTRY", "registry.lema.ai")
+	policy := `{"Statement":[{"Resource":"arn:aws:ecr:*:*:repository/${aws:username}/*"}]}`
+
+	tests := []struct {
+		name    string
+		raw     map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "references",
+			raw: map[string]interface{}{
+				"base_image": "${IPPON_TEST_REGISTRY}/base:latest",
+				"tags":       []interface{}{"$IPPON_TEST_REGISTRY", "${IPPON_TEST_UNSET:-dev}"},
+			},
+			want: map[string]interface{}{
+				"base_image": "registry.lema.ai/base:latest",
+				"tags":       []interface{}{"registry.lema.ai", "dev"},
+			},
+		},
+		{
+			name: "escaped dollar",
+			raw: map[string]interface{}{
+				"registry_headers": map[string]interface{}{"x-price": "5$$", "x-ref": "$${IPPON_TEST_REGISTRY}"},
+			},
+			want: map[string]interface{}{
+				"registry_headers": map[string]interface{}{"x-price": "5$", "x-ref": "${IPPON_TEST_REGISTRY}"},
+			},
+		},
+		{
+			name: "repository policy left alone",
+			raw: map[string]interface{}{
+				"ecr": map[string]interface{}{"account": "${IPPON_TEST_UNSET:-123456789012}", "repository_policy": policy},
+			},
+			want: map[string]interface{}{
+				"ecr": map[string]interface{}{"account": "123456789012", "repository_policy": policy},
+			},
+		},
+		{
+			name: "unset variable",
+			raw: map[string]interface{}{
+				"go_services": []interface{}{map[string]interface{}{"name": "${IPPON_TEST_UNSET}"}},
+			},
+			wantErr: "IPPON_TEST_UNSET (in go_services[0].name)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandRaw(test.raw)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expanded %v, want %v", got, test.want)
+			}
+		})
+	}
+}
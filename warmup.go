@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// warmupFirstService selects the first declared service as the warmup service.
+const warmupFirstService = "first"
+
+// splitWarmupService takes the cache warmup service out of services, so it can be built
+// alone before the rest reuse its Go build cache. The warmup service is expected to be
+// declared first: strict fails when it is missing or misordered, relaxed only logs it.
+// Without a warmup service every service builds concurrently.
+func splitWarmupService(services []GoServiceConfig, warmup string, strict bool) (*GoServiceConfig, []GoServiceConfig, error) {
+	if warmup == "" || len(services) == 0 {
+		return nil, services, nil
+	}
+	if warmup == warmupFirstService {
+		warmup = services[0].Name
+	}
+
+	service, idx, ok := lo.FindIndexOf(services, func(s GoServiceConfig) bool {
+		return s.Name == warmup
+	})
+	if !ok {
+		if strict {
+			return nil, nil, errors.Errorf("warmup service %s not found in go_services", warmup)
+		}
+		log.Printf("ippon warmup service %s not found, building without warmup\n", warmup)
+		return nil, services, nil
+	}
+
+	if idx != 0 {
+		if strict {
+			return nil, nil, errors.Errorf("expected warmup service %s to be first in go_services", warmup)
+		}
+		log.Printf("ippon warmup service %s is not first in go_services, building it first anyway\n", warmup)
+	}
+
+	rest := append(append([]GoServiceConfig{}, services[:idx]...), services[idx+1:]...)
+	return &service, rest, nil
+}